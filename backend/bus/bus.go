@@ -0,0 +1,15 @@
+package bus
+
+import "context"
+
+// Handler получает полезную нагрузку опубликованного сообщения.
+type Handler func(payload []byte)
+
+// Bus рассылает события всем экземплярам сервиса, включая тот, который их
+// опубликовал. MemoryBus подходит для одного экземпляра, PostgresBus — когда
+// их несколько за балансировщиком.
+type Bus interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Subscribe(channel string, handler Handler) error
+	Close() error
+}
@@ -0,0 +1,37 @@
+package bus
+
+import (
+	"context"
+	"sync"
+)
+
+type MemoryBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{handlers: make(map[string][]Handler)}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, channel string, payload []byte) error {
+	b.mu.RLock()
+	handlers := b.handlers[channel]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(channel string, handler Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[channel] = append(b.handlers[channel], handler)
+	return nil
+}
+
+func (b *MemoryBus) Close() error {
+	return nil
+}
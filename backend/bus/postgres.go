@@ -0,0 +1,105 @@
+package bus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxNotifyPayload — ограничение PostgreSQL на размер полезной нагрузки NOTIFY.
+const maxNotifyPayload = 8000
+
+var ErrPayloadTooLarge = errors.New("bus: payload too large")
+
+// PostgresBus передаёт события через LISTEN/NOTIFY. Публикация идёт через
+// общий пул соединений, а для подписки держится отдельное соединение,
+// которое pq.Listener восстанавливает после обрыва. События, отправленные
+// во время обрыва, теряются.
+type PostgresBus struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	done     chan struct{}
+}
+
+func NewPostgresBus(db *sql.DB, connStr string) *PostgresBus {
+	b := &PostgresBus{
+		db:       db,
+		handlers: make(map[string][]Handler),
+		done:     make(chan struct{}),
+	}
+	b.listener = pq.NewListener(connStr, time.Second, time.Minute, b.logEvent)
+	go b.dispatch()
+	return b
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, channel string, payload []byte) error {
+	if len(payload) > maxNotifyPayload {
+		return ErrPayloadTooLarge
+	}
+	_, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, string(payload))
+	return err
+}
+
+func (b *PostgresBus) Subscribe(channel string, handler Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.handlers[channel]; !ok {
+		if err := b.listener.Listen(channel); err != nil {
+			return err
+		}
+	}
+	b.handlers[channel] = append(b.handlers[channel], handler)
+	return nil
+}
+
+func (b *PostgresBus) Close() error {
+	close(b.done)
+	return b.listener.Close()
+}
+
+func (b *PostgresBus) dispatch() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			// nil приходит после переподключения.
+			if n == nil {
+				continue
+			}
+
+			b.mu.RLock()
+			handlers := b.handlers[n.Channel]
+			b.mu.RUnlock()
+
+			for _, handler := range handlers {
+				handler([]byte(n.Extra))
+			}
+		case <-time.After(90 * time.Second):
+			go b.listener.Ping()
+		}
+	}
+}
+
+func (b *PostgresBus) logEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.Printf("Соединение LISTEN потеряно: %v", err)
+	case pq.ListenerEventReconnected:
+		log.Println("Соединение LISTEN восстановлено")
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Printf("Не удалось восстановить соединение LISTEN: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"backend/config"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	description string
+	run         func(args []string, db *sql.DB, cfg *config.Config) error
+}
+
+var registry = map[string]command{
+	"create-admin": {
+		description: "создать первого администратора",
+		run:         createAdmin,
+	},
+	"rebuild-search-index": {
+		description: "перестроить поисковые индексы инцидентов",
+		run:         rebuildSearchIndex,
+	},
+}
+
+// Run выполняет служебную команду, переданную в аргументах запуска, вместо
+// старта HTTP-сервера.
+func Run(args []string, db *sql.DB, cfg *config.Config) error {
+	cmd, ok := registry[args[0]]
+	if !ok {
+		return fmt.Errorf("неизвестная команда %q, доступны: %s", args[0], available())
+	}
+	return cmd.run(args[1:], db, cfg)
+}
+
+func available() string {
+	names := make([]string, 0, len(registry))
+	for name, cmd := range registry {
+		names = append(names, name+" ("+cmd.description+")")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createAdmin создаёт администратора, если в системе ещё нет ни одного.
+// Пароль берётся из флага или переменной ADMIN_PASSWORD; если он не задан,
+// генерируется временный и печатается в stdout.
+func createAdmin(args []string, db *sql.DB, cfg *config.Config) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "admin", "имя пользователя")
+	email := fs.String("email", "", "email администратора")
+	password := fs.String("password", os.Getenv("ADMIN_PASSWORD"), "пароль (по умолчанию ADMIN_PASSWORD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("необходимо указать -email")
+	}
+	if *password != "" && !models.ValidPassword(*password) {
+		return errors.New(models.PasswordRequirements)
+	}
+
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE role = $1)", models.RoleAdmin).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.New("администратор уже существует, используйте API управления пользователями")
+	}
+
+	temporary := *password == ""
+	if temporary {
+		*password, err = utils.RandomString(12)
+		if err != nil {
+			return err
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	var userID uuid.UUID
+	err = db.QueryRow(
+		"INSERT INTO users (username, password_hash, email, role, must_change_password) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		*username, hashedPassword, *email, models.RoleAdmin, temporary,
+	).Scan(&userID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Администратор %s создан (id %s)\n", *username, userID)
+	if temporary {
+		fmt.Printf("Временный пароль: %s\n", *password)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"backend/config"
+	"backend/utils"
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// rebuildSearchIndex заново считает слепые индексы инцидентов и их сообщений.
+// Нужна для данных, созданных до появления поиска, и после смены
+// CRYPTO_SEARCH_KEY.
+func rebuildSearchIndex(args []string, db *sql.DB, cfg *config.Config) error {
+	fs := flag.NewFlagSet("rebuild-search-index", flag.ContinueOnError)
+	batch := fs.Int("batch", 500, "сколько строк обрабатывать за один запрос")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *batch <= 0 {
+		return fmt.Errorf("-batch должен быть больше нуля")
+	}
+
+	key := []byte(cfg.Crypto.Key)
+	searchKey := []byte(cfg.Crypto.SearchKey)
+
+	incidents, err := rebuildTable(db, *batch,
+		"SELECT id, subject, excerpt FROM incidents WHERE id > $1 ORDER BY id LIMIT $2",
+		"UPDATE incidents SET search_index = $2 WHERE id = $1",
+		func(rows *sql.Rows) (uuid.UUID, []string, error) {
+			var id uuid.UUID
+			var subject, encryptedExcerpt string
+			if err := rows.Scan(&id, &subject, &encryptedExcerpt); err != nil {
+				return id, nil, err
+			}
+			excerpt, err := utils.Decrypt(encryptedExcerpt, key)
+			if err != nil {
+				return id, nil, fmt.Errorf("инцидент %s: %w", id, err)
+			}
+			return id, utils.BlindIndex(subject+" "+string(excerpt), searchKey), nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	messages, err := rebuildTable(db, *batch,
+		"SELECT id, message FROM incident_messages WHERE id > $1 ORDER BY id LIMIT $2",
+		"UPDATE incident_messages SET search_index = $2 WHERE id = $1",
+		func(rows *sql.Rows) (uuid.UUID, []string, error) {
+			var id uuid.UUID
+			var encryptedMessage string
+			if err := rows.Scan(&id, &encryptedMessage); err != nil {
+				return id, nil, err
+			}
+			message, err := utils.Decrypt(encryptedMessage, key)
+			if err != nil {
+				return id, nil, fmt.Errorf("сообщение инцидента %s: %w", id, err)
+			}
+			return id, utils.BlindIndex(string(message), searchKey), nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Поисковые индексы перестроены: инцидентов %d, сообщений %d\n", incidents, messages)
+	return nil
+}
+
+// rebuildTable проходит таблицу пачками по id и записывает индекс, который
+// index считает для каждой строки. Возвращает число обновлённых строк.
+func rebuildTable(db *sql.DB, batch int, selectQuery, updateQuery string, index func(*sql.Rows) (uuid.UUID, []string, error)) (int, error) {
+	type row struct {
+		id     uuid.UUID
+		tokens []string
+	}
+
+	var total int
+	var last uuid.UUID
+	for {
+		rows, err := db.Query(selectQuery, last, batch)
+		if err != nil {
+			return total, err
+		}
+
+		var page []row
+		for rows.Next() {
+			id, tokens, err := index(rows)
+			if err != nil {
+				rows.Close()
+				return total, err
+			}
+			page = append(page, row{id: id, tokens: tokens})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(page) == 0 {
+			return total, nil
+		}
+
+		for _, r := range page {
+			if _, err := db.Exec(updateQuery, r.id, pq.Array(r.tokens)); err != nil {
+				return total, err
+			}
+		}
+		total += len(page)
+		last = page[len(page)-1].id
+	}
+}
@@ -1,20 +1,41 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
+// defaultSearchKey — ключ слепых индексов по умолчанию. С ним индексы может
+// посчитать любой, кто знает исходный код, поэтому он допустим только при
+// разработке.
+const defaultSearchKey = "search-index-key-change-me"
+
 type Config struct {
+	// Env — окружение запуска из APP_ENV: development или production.
+	Env      string
 	Server   ServerConfig
 	Database DatabaseConfig
 	JWT      JWTConfig
 	Crypto   CryptoConfig
+	Security SecurityConfig
+	Mail     MailConfig
+	Chat     ChatConfig
 }
 
 type ServerConfig struct {
 	Port string
+	// AllowedOrigins — адреса фронтендов, которым разрешено открывать
+	// WebSocket. Пустой список означает только тот же хост.
+	AllowedOrigins []string
+	// TrustedProxies — адреса или подсети обратных прокси, которым можно
+	// верить в X-Forwarded-For. Пустой список — прокси нет, IP клиента
+	// берётся из соединения.
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -27,12 +48,72 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret     string
-	ExpireTime int
+	// KeysDir — каталог с PEM-ключами подписи. Если не задан, при запуске
+	// создаётся временный ключ, и токены не переживают перезапуск.
+	KeysDir             string
+	SigningKeyID        string
+	Issuer              string
+	AccessExpireMinutes int
+	RefreshExpireHours  int
 }
 
 type CryptoConfig struct {
 	Key string
+	// SearchKey — ключ HMAC слепых индексов для поиска по зашифрованному
+	// тексту. Должен отличаться от Key; после его смены индексы
+	// перестраиваются командой rebuild-search-index.
+	SearchKey string
+}
+
+type SecurityConfig struct {
+	// LimiterStore — "memory" для одного экземпляра или "postgres", когда
+	// экземпляров несколько.
+	LimiterStore        string
+	LoginMaxFailures    int
+	LoginLockoutMinutes int
+	TOTPMaxFailures     int
+}
+
+type MailConfig struct {
+	// Driver — "smtp" или "file". Для "file" без Dir письма печатаются в лог.
+	Driver   string
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Dir      string
+	// AppURL — адрес фронтенда, на который ведут ссылки из писем.
+	AppURL                 string
+	ResetTokenMinutes      int
+	VerificationTokenHours int
+}
+
+type ChatConfig struct {
+	// Bus — "memory" для одного экземпляра или "postgres" (LISTEN/NOTIFY),
+	// чтобы сообщения доходили до клиентов, подключённых к другим экземплярам.
+	Bus string
+	// MaxFrameBytes — наибольший размер кадра WebSocket от клиента.
+	MaxFrameBytes int64
+	// MaxMessageLength — наибольшая длина сообщения чата в символах.
+	MaxMessageLength int
+	// AttachmentsDir — каталог вложений чата. Он не должен раздаваться
+	// статикой: вложения отдаются только участникам беседы.
+	AttachmentsDir     string
+	MaxAttachmentBytes int64
+	// AttachmentTypes — допустимые MIME-типы вложений; тип определяется по
+	// содержимому файла, а не по имени.
+	AttachmentTypes []string
+	// OrphanAttachmentHours — через сколько часов удаляются вложения,
+	// которые загрузили, но так и не отправили в сообщении. 0 — не удалять.
+	OrphanAttachmentHours int
+	// EditWindowMinutes — сколько минут после отправки автор может изменить
+	// или удалить сообщение.
+	EditWindowMinutes int
+	// ExportTimezone — часовой пояс времени в выгрузках переписки.
+	ExportTimezone string
+	// ExportFont — TTF-шрифт с кириллицей для выгрузки в PDF.
+	ExportFont string
 }
 
 func LoadConfig() (*Config, error) {
@@ -41,8 +122,11 @@ func LoadConfig() (*Config, error) {
 	}
 
 	config := &Config{
+		Env: getEnv("APP_ENV", "production"),
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
+			Port:           getEnv("SERVER_PORT", "8080"),
+			AllowedOrigins: getEnvList("WS_ALLOWED_ORIGINS", ""),
+			TrustedProxies: getEnvList("TRUSTED_PROXIES", ""),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -53,14 +137,59 @@ func LoadConfig() (*Config, error) {
 			SSLMode:  getEnv("DB_SSLMODE", "disable"),
 		},
 		JWT: JWTConfig{
-			Secret:     getEnv("JWT_SECRET", "your-secret-key"),
-			ExpireTime: 24,
+			KeysDir:             os.Getenv("JWT_KEYS_DIR"),
+			SigningKeyID:        os.Getenv("JWT_SIGNING_KEY_ID"),
+			Issuer:              getEnv("JWT_ISSUER", "backend"),
+			AccessExpireMinutes: getEnvInt("JWT_ACCESS_EXPIRE_MINUTES", 15),
+			RefreshExpireHours:  getEnvInt("JWT_REFRESH_EXPIRE_HOURS", 720),
 		},
 		Crypto: CryptoConfig{
-			Key: getEnv("CRYPTO_KEY", "32-byte-key-for-aes-256-encryption"),
+			Key:       getEnv("CRYPTO_KEY", "32-byte-key-for-aes-256-encryption"),
+			SearchKey: getEnv("CRYPTO_SEARCH_KEY", defaultSearchKey),
+		},
+		Security: SecurityConfig{
+			LimiterStore:        getEnv("LIMITER_STORE", "memory"),
+			LoginMaxFailures:    getEnvInt("LOGIN_MAX_FAILURES", 10),
+			LoginLockoutMinutes: getEnvInt("LOGIN_LOCKOUT_MINUTES", 15),
+			TOTPMaxFailures:     getEnvInt("TOTP_MAX_FAILURES", 5),
+		},
+		Mail: MailConfig{
+			Driver:                 getEnv("MAIL_DRIVER", "file"),
+			Host:                   getEnv("SMTP_HOST", "localhost"),
+			Port:                   getEnv("SMTP_PORT", "587"),
+			Username:               os.Getenv("SMTP_USERNAME"),
+			Password:               os.Getenv("SMTP_PASSWORD"),
+			From:                   getEnv("MAIL_FROM", "no-reply@localhost"),
+			Dir:                    os.Getenv("MAIL_DIR"),
+			AppURL:                 getEnv("APP_URL", "http://localhost:3000"),
+			ResetTokenMinutes:      getEnvInt("PASSWORD_RESET_TOKEN_MINUTES", 60),
+			VerificationTokenHours: getEnvInt("EMAIL_VERIFICATION_TOKEN_HOURS", 48),
+		},
+		Chat: ChatConfig{
+			Bus:                getEnv("CHAT_BUS", "memory"),
+			MaxFrameBytes:      int64(getEnvInt("CHAT_MAX_FRAME_BYTES", 16<<10)),
+			MaxMessageLength:   getEnvInt("CHAT_MAX_MESSAGE_LENGTH", 4000),
+			AttachmentsDir:     getEnv("CHAT_ATTACHMENTS_DIR", "data/chat_attachments"),
+			MaxAttachmentBytes: int64(getEnvInt("CHAT_MAX_ATTACHMENT_BYTES", 10<<20)),
+			AttachmentTypes: getEnvList("CHAT_ATTACHMENT_TYPES",
+				"image/jpeg,image/png,image/gif,image/webp,application/pdf,audio/mpeg,audio/wave,application/ogg,video/webm"),
+			OrphanAttachmentHours: getEnvInt("CHAT_ORPHAN_ATTACHMENT_HOURS", 24),
+			EditWindowMinutes:     getEnvInt("CHAT_EDIT_WINDOW_MINUTES", 15),
+			ExportTimezone:        getEnv("CHAT_EXPORT_TIMEZONE", "Asia/Almaty"),
+			ExportFont:            getEnv("CHAT_EXPORT_FONT", "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"),
 		},
 	}
 
+	if config.Env != "development" && config.Crypto.SearchKey == defaultSearchKey {
+		return nil, errors.New("CRYPTO_SEARCH_KEY не задан: ключ по умолчанию допустим только при APP_ENV=development")
+	}
+
+	// Без шрифта выгрузка в PDF падает только при первом запросе, поэтому
+	// путь проверяется при запуске.
+	if _, err := os.Stat(config.Chat.ExportFont); err != nil {
+		return nil, fmt.Errorf("CHAT_EXPORT_FONT: шрифт для выгрузки в PDF недоступен: %w", err)
+	}
+
 	return config, nil
 }
 
@@ -71,3 +200,21 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvList(key, defaultValue string) []string {
+	var result []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
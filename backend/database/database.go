@@ -8,13 +8,16 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func InitDB(cfg config.DatabaseConfig) (*sql.DB, error) {
-	connStr := fmt.Sprintf(
+// ConnString собирает строку подключения для lib/pq.
+func ConnString(cfg config.DatabaseConfig) string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
 	)
+}
 
-	db, err := sql.Open("postgres", connStr)
+func InitDB(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", ConnString(cfg))
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +30,10 @@ func InitDB(cfg config.DatabaseConfig) (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err = migrateTables(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -88,6 +95,29 @@ func createTables(db *sql.DB) error {
 		return err
 	}
 
+	// incident_status_history.from_status пуст у записи о создании инцидента.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS incident_status_history (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			incident_id UUID NOT NULL REFERENCES incidents(id) ON DELETE CASCADE,
+			from_status VARCHAR(20),
+			to_status VARCHAR(20) NOT NULL,
+			reason TEXT,
+			changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS incident_status_history_incident ON incident_status_history (incident_id, created_at)
+	`)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS chat_messages (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -101,5 +131,362 @@ func createTables(db *sql.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			kind VARCHAR(20) NOT NULL,
+			title VARCHAR(200),
+			citizen_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS conversations_department_citizen
+		ON conversations (citizen_id) WHERE kind = 'department'
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversation_members (
+			conversation_id UUID REFERENCES conversations(id) ON DELETE CASCADE,
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_read_at TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// chat_connections — открытые WebSocket-соединения всех экземпляров.
+	// Строки соединений, чей heartbeat_at давно не обновлялся, остались от
+	// упавшего экземпляра и не учитываются.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_connections (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL,
+			connected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			heartbeat_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS chat_connections_user ON chat_connections (user_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_message_receipts (
+			message_id UUID REFERENCES chat_messages(id) ON DELETE CASCADE,
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			delivered_at TIMESTAMP,
+			read_at TIMESTAMP,
+			PRIMARY KEY (message_id, user_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// chat_attachments.message_id пуст, пока вложение не отправлено.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_attachments (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+			message_id UUID REFERENCES chat_messages(id) ON DELETE CASCADE,
+			uploader_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			kind VARCHAR(20) NOT NULL,
+			file_name VARCHAR(255) NOT NULL,
+			mime_type VARCHAR(100) NOT NULL,
+			size BIGINT NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			width INTEGER,
+			height INTEGER,
+			duration_ms INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS chat_attachments_message ON chat_attachments (message_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// chat_message_revisions хранит зашифрованный текст сообщения до каждой
+	// правки, удаления и скрытия модератором.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_message_revisions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			message_id UUID NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+			action VARCHAR(20) NOT NULL,
+			message TEXT NOT NULL,
+			actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			reason TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS chat_message_revisions_message ON chat_message_revisions (message_id, created_at)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversation_transfers (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+			from_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			to_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			transferred_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			note TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// canned_responses.owner_id пуст у общих шаблонов департамента.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS canned_responses (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			owner_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			title VARCHAR(100) NOT NULL,
+			body TEXT NOT NULL,
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// audit_log — кто и что сделал с чувствительными данными.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			action VARCHAR(50) NOT NULL,
+			entity_type VARCHAR(50) NOT NULL,
+			entity_id UUID,
+			details JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity_type, entity_id, created_at)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS two_fa_recovery_codes (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(100) NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			refresh_token_hash VARCHAR(64) UNIQUE NOT NULL,
+			user_agent VARCHAR(255),
+			ip_address VARCHAR(64),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS session_rotated_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+			rotated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(30) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS ws_tickets (
+			ticket_hash VARCHAR(64) PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+			role VARCHAR(20) NOT NULL,
+			token_expires_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			key VARCHAR(255) PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrations дополняют таблицы, созданные предыдущими версиями сервиса.
+// Каждая инструкция должна быть идемпотентной.
+var migrations = []string{
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS two_fa_enabled BOOLEAN`,
+	// Раньше секрет выдавался при регистрации и сразу считался активным.
+	`UPDATE users SET two_fa_enabled = (COALESCE(two_fa_secret, '') <> '') WHERE two_fa_enabled IS NULL`,
+	`ALTER TABLE users ALTER COLUMN two_fa_enabled SET DEFAULT FALSE`,
+	`ALTER TABLE users ALTER COLUMN two_fa_enabled SET NOT NULL`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS two_fa_pending_secret VARCHAR(50)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS two_fa_last_step BIGINT`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP`,
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS conversation_id UUID REFERENCES conversations(id) ON DELETE CASCADE`,
+	`CREATE INDEX IF NOT EXISTS chat_messages_conversation_created ON chat_messages (conversation_id, created_at DESC, id DESC)`,
+	// Старые сообщения граждан переносятся в их обращения в департамент.
+	`INSERT INTO conversations (kind, citizen_id, created_by, created_at)
+		SELECT 'department', u.id, u.id, MIN(cm.created_at)
+		FROM chat_messages cm
+		JOIN users u ON u.role = 'citizen' AND (u.id = cm.sender_id OR u.id = cm.recipient_id)
+		WHERE cm.conversation_id IS NULL
+		GROUP BY u.id
+		ON CONFLICT (citizen_id) WHERE kind = 'department' DO NOTHING`,
+	`UPDATE chat_messages cm SET conversation_id = c.id
+		FROM conversations c
+		WHERE cm.conversation_id IS NULL AND c.kind = 'department'
+			AND (c.citizen_id = cm.sender_id OR c.citizen_id = cm.recipient_id)`,
+	`INSERT INTO conversation_members (conversation_id, user_id)
+		SELECT id, citizen_id FROM conversations WHERE kind = 'department'
+		ON CONFLICT DO NOTHING`,
+	// client_message_id — идентификатор, который клиент присваивает сообщению,
+	// чтобы повторная отправка после обрыва связи не создавала дубликат.
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS client_message_id UUID`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS chat_messages_sender_client_id
+		ON chat_messages (sender_id, client_message_id) WHERE client_message_id IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS chat_messages_created ON chat_messages (created_at, id)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP`,
+	// CreateIncident и GetIncidents всегда работали с координатами, но в
+	// таблице их не было.
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION`,
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION`,
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP`,
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS redacted BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE conversations ADD COLUMN IF NOT EXISTS assigned_to UUID REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE conversations ADD COLUMN IF NOT EXISTS assigned_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS conversations_assigned_to ON conversations (assigned_to)`,
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'new'`,
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP`,
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS assigned_officer_id UUID REFERENCES users(id) ON DELETE SET NULL`,
+	// station_id — участок из списка policeStations, таблицы участков пока нет.
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS station_id INTEGER`,
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS assigned_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS incidents_assigned_officer ON incidents (assigned_officer_id)`,
+	`CREATE INDEX IF NOT EXISTS incidents_created ON incidents (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS incidents_status_created ON incidents (status, created_at, id)`,
+	// Слепые индексы (HMAC слов) зашифрованного текста для поиска.
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS search_index TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE incident_messages ADD COLUMN IF NOT EXISTS search_index TEXT[] NOT NULL DEFAULT '{}'`,
+	`CREATE INDEX IF NOT EXISTS incidents_search_index ON incidents USING GIN (search_index)`,
+	`CREATE INDEX IF NOT EXISTS incident_messages_search_index ON incident_messages USING GIN (search_index)`,
+	// Заявитель — авторизованный пользователь или владелец кода отслеживания,
+	// который хранится только в виде хеша.
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS citizen_id UUID REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS tracking_code_hash VARCHAR(64) UNIQUE`,
+	`CREATE INDEX IF NOT EXISTS incidents_citizen ON incidents (citizen_id, created_at)`,
+	`ALTER TABLE incident_messages ADD COLUMN IF NOT EXISTS from_citizen BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE incident_messages ADD COLUMN IF NOT EXISTS internal BOOLEAN NOT NULL DEFAULT FALSE`,
+	// Билетом, по которому открыт поток /api/events, можно переподключиться,
+	// пока он не истёк.
+	`ALTER TABLE ws_tickets ADD COLUMN IF NOT EXISTS event_stream BOOLEAN NOT NULL DEFAULT FALSE`,
+	// У инцидентов, созданных до появления истории статусов, записываются
+	// создание и, если статус уже менялся, переход в текущий статус.
+	`WITH missing AS (
+		SELECT i.id, i.status, i.created_at, i.status_changed_at
+		FROM incidents i
+		WHERE NOT EXISTS (SELECT 1 FROM incident_status_history h WHERE h.incident_id = i.id)
+	)
+	INSERT INTO incident_status_history (incident_id, from_status, to_status, created_at)
+	SELECT id, NULL, 'new', created_at FROM missing
+	UNION ALL
+	SELECT id, 'new', status, COALESCE(status_changed_at, created_at) FROM missing WHERE status <> 'new'`,
+	// Зашифрованный токен, выданный взамен, — для повторного запроса в
+	// течение refreshGracePeriod после ротации.
+	`ALTER TABLE session_rotated_tokens ADD COLUMN IF NOT EXISTS successor TEXT`,
+}
+
+func migrateTables(db *sql.DB) error {
+	for _, statement := range migrations {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
 	return nil
 }
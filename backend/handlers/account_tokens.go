@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"backend/config"
+	"backend/mail"
+	"backend/models"
+	"backend/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tokenPurposePasswordReset     = "password_reset"
+	tokenPurposeEmailVerification = "email_verification"
+
+	// mailSendTimeout ограничивает отправку писем в фоне, когда контекста
+	// запроса уже нет.
+	mailSendTimeout = 30 * time.Second
+)
+
+func newMailer(cfg config.MailConfig) mail.Mailer {
+	if cfg.Driver == "smtp" {
+		return mail.NewSMTPMailer(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.From)
+	}
+	return mail.NewFileMailer(cfg.Dir, cfg.From)
+}
+
+// issueUserToken выдаёт одноразовый токен с заданным назначением. Ранее
+// выданные неиспользованные токены того же назначения аннулируются.
+func (h *Handler) issueUserToken(userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID, purpose,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))",
+		userID, purpose, utils.HashToken(token), ttl.Seconds(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// consumeUserToken атомарно помечает токен использованным и возвращает его
+// владельца. Для неизвестного, просроченного или уже использованного токена
+// возвращается sql.ErrNoRows.
+func (h *Handler) consumeUserToken(token, purpose string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := h.DB.QueryRow(`
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, utils.HashToken(token), purpose).Scan(&userID)
+	return userID, err
+}
+
+func (h *Handler) appLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", h.Config.Mail.AppURL, path, url.QueryEscape(token))
+}
+
+func (h *Handler) sendVerificationEmail(c *gin.Context, userID uuid.UUID, email string) error {
+	ttl := time.Duration(h.Config.Mail.VerificationTokenHours) * time.Hour
+	token, err := h.issueUserToken(userID, tokenPurposeEmailVerification, ttl)
+	if err != nil {
+		return err
+	}
+
+	return h.Mailer.Send(c.Request.Context(), mail.Message{
+		To:      email,
+		Subject: "Подтверждение адреса электронной почты",
+		Body: fmt.Sprintf(
+			"Здравствуйте!\n\nЧтобы подтвердить адрес электронной почты, перейдите по ссылке:\n%s\n\nСсылка действительна %d ч.\n",
+			h.appLink("/verify-email", token), h.Config.Mail.VerificationTokenHours,
+		),
+	})
+}
+
+// ForgotPassword всегда отвечает одинаково и сразу, чтобы ни по ответу, ни
+// по времени ответа нельзя было узнать, зарегистрирован ли адрес: поиск
+// пользователя и отправка письма идут в фоне. Запросы ограничены по IP и по
+// адресу, чтобы через форму нельзя было заваливать чужой ящик письмами.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !reserveAttempt(c, h.Guards.PasswordReset, resetIPKey(c.ClientIP()), resetEmailKey(req.Email)) {
+		return
+	}
+
+	go h.sendPasswordResetEmail(req.Email)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Если адрес зарегистрирован, на него отправлена ссылка для сброса пароля"})
+}
+
+// sendPasswordResetEmail отправляет ссылку для сброса, если адрес
+// принадлежит активному пользователю. Ошибки только пишутся в лог: клиент
+// уже получил ответ.
+func (h *Handler) sendPasswordResetEmail(email string) {
+	var userID uuid.UUID
+	err := h.DB.QueryRow(
+		"SELECT id FROM users WHERE email = $1 AND disabled_at IS NULL",
+		email,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения пользователя для сброса пароля: %v", err)
+		return
+	}
+
+	ttl := time.Duration(h.Config.Mail.ResetTokenMinutes) * time.Minute
+	token, err := h.issueUserToken(userID, tokenPurposePasswordReset, ttl)
+	if err != nil {
+		log.Printf("Ошибка создания токена для сброса пароля: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+	defer cancel()
+
+	err = h.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf(
+			"Здравствуйте!\n\nДля сброса пароля перейдите по ссылке:\n%s\n\nСсылка действительна %d мин. Если вы не запрашивали сброс, просто проигнорируйте это письмо.\n",
+			h.appLink("/reset-password", token), h.Config.Mail.ResetTokenMinutes,
+		),
+	})
+	if err != nil {
+		log.Printf("Ошибка отправки письма для сброса пароля: %v", err)
+	}
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.ValidPassword(req.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": models.PasswordRequirements})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка хеширования пароля"})
+		return
+	}
+
+	userID, err := h.consumeUserToken(req.Token, tokenPurposePasswordReset)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ссылка недействительна или устарела"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки токена"})
+		return
+	}
+
+	// Письмо пришло на этот адрес, значит он заодно подтверждён.
+	var username string
+	err = h.DB.QueryRow(`
+		UPDATE users
+		SET password_hash = $1, must_change_password = FALSE, email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $2
+		RETURNING username
+	`, hashedPassword, userID).Scan(&username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка смены пароля"})
+		return
+	}
+
+	if err := h.revokeUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессий"})
+		return
+	}
+
+	if err := h.Guards.Account.Reset(c.Request.Context(), accountKey(username)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка разблокировки входа"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменён, войдите с новым паролем"})
+}
+
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.consumeUserToken(req.Token, tokenPurposeEmailVerification)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ссылка недействительна или устарела"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки токена"})
+		return
+	}
+
+	_, err = h.DB.Exec("UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка подтверждения email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Адрес электронной почты подтверждён"})
+}
+
+func (h *Handler) ResendVerificationEmail(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var email string
+	var verifiedAt *time.Time
+	err := h.DB.QueryRow("SELECT email, email_verified_at FROM users WHERE id = $1", userID).Scan(&email, &verifiedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	if verifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Адрес электронной почты уже подтверждён"})
+		return
+	}
+
+	if err := h.sendVerificationEmail(c, userID, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отправки письма"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Письмо с подтверждением отправлено"})
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const temporaryPasswordLength = 12
+
+// CreateUser создаёт сотрудника от имени администратора. Если пароль не задан,
+// генерируется временный, который нужно сменить при первом входе.
+func (h *Handler) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестная роль"})
+		return
+	}
+
+	password := req.Password
+	if password == "" {
+		var err error
+		password, err = utils.RandomString(temporaryPasswordLength)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации пароля"})
+			return
+		}
+	} else if !models.ValidPassword(password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": models.PasswordRequirements})
+		return
+	}
+
+	exists, err := h.userExists(req.Username, req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки пользователя"})
+		return
+	}
+
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким именем или email уже существует"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка хеширования пароля"})
+		return
+	}
+
+	var userID uuid.UUID
+	err = h.DB.QueryRow(
+		"INSERT INTO users (username, password_hash, email, role, must_change_password) VALUES ($1, $2, $3, $4, TRUE) RETURNING id",
+		req.Username, hashedPassword, req.Email, req.Role,
+	).Scan(&userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания пользователя"})
+		return
+	}
+
+	response := gin.H{
+		"message": "Пользователь создан",
+		"user_id": userID,
+	}
+	if req.Password == "" {
+		response["temporary_password"] = password
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *Handler) ListUsers(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	var conditions []string
+	var args []interface{}
+
+	if role := c.Query("role"); role != "" {
+		args = append(args, role)
+		conditions = append(conditions, fmt.Sprintf("role = $%d", len(args)))
+	}
+
+	switch c.Query("status") {
+	case "active":
+		conditions = append(conditions, "disabled_at IS NULL")
+	case "disabled":
+		conditions = append(conditions, "disabled_at IS NOT NULL")
+	case "":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Статус должен быть 'active' или 'disabled'"})
+		return
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		args = append(args, "%"+q+"%")
+		conditions = append(conditions, fmt.Sprintf("(username ILIKE $%d OR email ILIKE $%d)", len(args), len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := h.DB.QueryRow("SELECT COUNT(*) FROM users "+where, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователей"})
+		return
+	}
+
+	args = append(args, limit, offset)
+	rows, err := h.DB.Query(fmt.Sprintf(`
+		SELECT id, username, email, role, two_fa_enabled, disabled_at, must_change_password, created_at
+		FROM users %s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователей"})
+		return
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.TwoFAEnabled, &u.DisabledAt, &u.MustChangePassword, &u.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных пользователя"})
+			return
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователей"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total})
+}
+
+func (h *Handler) UpdateUserRole(c *gin.Context) {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестная роль"})
+		return
+	}
+
+	if _, err := h.DB.Exec("UPDATE users SET role = $1 WHERE id = $2", req.Role, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка изменения роли"})
+		return
+	}
+
+	// Роль зашита в выданные токены, поэтому старые сессии больше не годятся.
+	if err := h.revokeUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Роль изменена", "role": req.Role})
+}
+
+func (h *Handler) DisableUser(c *gin.Context) {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec("UPDATE users SET disabled_at = NOW() WHERE id = $1 AND disabled_at IS NULL", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка блокировки пользователя"})
+		return
+	}
+
+	if err := h.revokeUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пользователь заблокирован"})
+}
+
+func (h *Handler) EnableUser(c *gin.Context) {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec("UPDATE users SET disabled_at = NULL WHERE id = $1", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка разблокировки пользователя"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пользователь разблокирован"})
+}
+
+// UnlockUser снимает блокировку, наложенную после серии неудачных попыток
+// входа.
+func (h *Handler) UnlockUser(c *gin.Context) {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	var username string
+	if err := h.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.Guards.Account.Reset(ctx, accountKey(username)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка разблокировки входа"})
+		return
+	}
+	if err := h.Guards.TOTP.Reset(ctx, totpKey(userID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка разблокировки входа"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Блокировка входа снята"})
+}
+
+// ResetUserPassword выдаёт пользователю временный пароль и завершает все его
+// сессии.
+func (h *Handler) ResetUserPassword(c *gin.Context) {
+	userID, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	password, err := utils.RandomString(temporaryPasswordLength)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации пароля"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка хеширования пароля"})
+		return
+	}
+
+	_, err = h.DB.Exec(
+		"UPDATE users SET password_hash = $1, must_change_password = TRUE WHERE id = $2",
+		hashedPassword, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сброса пароля"})
+		return
+	}
+
+	if err := h.revokeUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "Пароль сброшен",
+		"temporary_password": password,
+	})
+}
+
+// targetUser разбирает :user_id, проверяет, что пользователь существует и что
+// администратор не пытается изменить собственную учётную запись.
+func (h *Handler) targetUser(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return uuid.Nil, false
+	}
+
+	if adminID, _, _ := currentUser(c); adminID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя изменить собственную учётную запись"})
+		return uuid.Nil, false
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return uuid.Nil, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
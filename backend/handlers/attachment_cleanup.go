@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const attachmentCleanupInterval = time.Hour
+
+// cleanupOrphanAttachments раз в attachmentCleanupInterval удаляет вложения,
+// которые загрузили, но так и не отправили в сообщении, чтобы брошенные
+// загрузки не копились на диске. Завершается в Shutdown.
+func (h *Handler) cleanupOrphanAttachments() {
+	ticker := time.NewTicker(attachmentCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := h.removeOrphanAttachments()
+		if err != nil {
+			log.Printf("Ошибка удаления неотправленных вложений: %v", err)
+		} else if removed > 0 {
+			log.Printf("Удалено неотправленных вложений: %d", removed)
+		}
+
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeOrphanAttachments удаляет строки вложений без сообщения старше
+// CHAT_ORPHAN_ATTACHMENT_HOURS, затем их файлы. Строка удаляется первой:
+// attachToMessage привязывает только существующие строки, поэтому файл
+// отправленного вложения не пропадёт.
+func (h *Handler) removeOrphanAttachments() (int, error) {
+	rows, err := h.DB.Query(`
+		DELETE FROM chat_attachments
+		WHERE message_id IS NULL AND created_at < NOW() - make_interval(hours => $1)
+		RETURNING id
+	`, h.Config.Chat.OrphanAttachmentHours)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := os.Remove(h.attachmentPath(id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Ошибка удаления файла вложения %s: %v", id, err)
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// execer — *sql.DB или *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// writeAudit записывает действие пользователя actorID над сущностью в
+// audit_log. details сохраняется как JSON. Если действие меняет данные,
+// запись делается в той же транзакции, чтобы изменение без неё не сохранилось.
+func writeAudit(db execer, actorID uuid.UUID, action, entityType string, entityID uuid.UUID, details interface{}) error {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO audit_log (actor_id, action, entity_type, entity_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, actorID, action, entityType, entityID, data)
+	return err
+}
@@ -2,8 +2,9 @@ package handlers
 
 import (
 	"backend/models"
-	"backend/utils"
+	"context"
 	"database/sql"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -18,51 +19,50 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	var exists bool
-	err := h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)",
-		req.Username, req.Email).Scan(&exists)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки пользователя"})
+	if req.Role != "" && req.Role != models.RoleCitizen {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Самостоятельная регистрация доступна только гражданам"})
 		return
 	}
 
-	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким именем или email уже существует"})
+	if !models.ValidPassword(req.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": models.PasswordRequirements})
 		return
 	}
 
-	if req.Role != "citizen" && req.Role != "police" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Роль должна быть 'citizen' или 'police'"})
+	exists, err := h.userExists(req.Username, req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки пользователя"})
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка хеширования пароля"})
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким именем или email уже существует"})
 		return
 	}
 
-	totpSecret, totpURL, err := utils.GenerateTOTPSecret(req.Username)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации TOTP"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка хеширования пароля"})
 		return
 	}
 
 	var userID uuid.UUID
 	err = h.DB.QueryRow(
-		"INSERT INTO users (username, password_hash, email, role, two_fa_secret) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		req.Username, hashedPassword, req.Email, req.Role, totpSecret,
+		"INSERT INTO users (username, password_hash, email, role) VALUES ($1, $2, $3, $4) RETURNING id",
+		req.Username, hashedPassword, req.Email, models.RoleCitizen,
 	).Scan(&userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания пользователя"})
 		return
 	}
 
+	if err := h.sendVerificationEmail(c, userID, req.Email); err != nil {
+		log.Printf("Ошибка отправки письма с подтверждением: %v", err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
-		"message":     "Пользователь успешно зарегистрирован",
-		"user_id":     userID,
-		"totp_url":    totpURL,
-		"totp_secret": totpSecret,
+		"message": "Пользователь успешно зарегистрирован",
+		"user_id": userID,
 	})
 }
 
@@ -73,43 +73,171 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	userKey, clientKey := accountKey(req.Username), ipKey(c.ClientIP())
+	if !reserveAttempt(c, h.Guards.IP, clientKey) {
+		return
+	}
+	// Попытка по заблокированной учётной записи пароль не проверяет, поэтому
+	// и по IP её засчитывать не за что.
+	if !reserveAttempt(c, h.Guards.Account, userKey) {
+		if err := h.Guards.IP.Release(ctx, clientKey); err != nil {
+			log.Printf("Ошибка снятия попытки входа по IP: %v", err)
+		}
+		return
+	}
+
 	var user models.User
 	var passwordHash string
-	var totpSecret string
-	err := h.DB.QueryRow(
-		"SELECT id, username, password_hash, two_fa_secret FROM users WHERE username = $1",
-		req.Username,
-	).Scan(&user.ID, &user.Username, &passwordHash, &totpSecret)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверное имя пользователя или пароль"})
-			return
-		}
+	var totpSecret sql.NullString
+	var lastStep sql.NullInt64
+	err := h.DB.QueryRow(`
+		SELECT id, username, role, password_hash, two_fa_enabled, two_fa_secret, two_fa_last_step,
+			disabled_at, must_change_password
+		FROM users WHERE username = $1
+	`, req.Username).Scan(
+		&user.ID, &user.Username, &user.Role, &passwordHash, &user.TwoFAEnabled, &totpSecret, &lastStep,
+		&user.DisabledAt, &user.MustChangePassword,
+	)
+	if err != nil && err != sql.ErrNoRows {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+	// Неудачная попытка уже засчитана reserveAttempt.
+	if err == sql.ErrNoRows || bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверное имя пользователя или пароль"})
 		return
 	}
 
-	if totpSecret != "" {
-		if req.TOTPCode == "" {
+	if user.DisabledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Учётная запись заблокирована"})
+		return
+	}
+
+	if user.TwoFAEnabled {
+		// Пароль верный, клиент просто ещё не знает, что нужен код: попытка
+		// по паролю не считается ошибкой.
+		if req.TOTPCode == "" && req.RecoveryCode == "" {
+			if err := h.releaseLoginAttempt(ctx, userKey, clientKey); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка учёта попытки входа"})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Требуется код двухфакторной аутентификации"})
 			return
 		}
-		if !utils.ValidateTOTP(totpSecret, req.TOTPCode) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный код двухфакторной аутентификации"})
+
+		if !reserveAttempt(c, h.Guards.TOTP, totpKey(user.ID)) {
 			return
 		}
+
+		var valid bool
+		if req.TOTPCode != "" {
+			valid, err = h.consumeTOTP(user.ID, totpSecret.String, lastStep.Int64, req.TOTPCode)
+		} else {
+			valid, err = h.consumeRecoveryCode(user.ID, req.RecoveryCode)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки кода"})
+			return
+		}
+		if !valid {
+			h.rejectTOTPCode(c)
+			return
+		}
+	}
+
+	if err := h.Guards.Account.Reset(ctx, userKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка учёта попытки входа"})
+		return
+	}
+	if err := h.Guards.IP.Release(ctx, clientKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка учёта попытки входа"})
+		return
+	}
+	if err := h.Guards.TOTP.Reset(ctx, totpKey(user.ID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка учёта попытки входа"})
+		return
 	}
 
-	token, err := utils.GenerateToken(user.ID, user.Username, h.Config.JWT.Secret, h.Config.JWT.ExpireTime)
+	response, err := h.startSession(c, user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сессии"})
 		return
 	}
+	response.MustChangePassword = user.MustChangePassword
 
-	c.JSON(http.StatusOK, models.TokenResponse{Token: token})
+	c.JSON(http.StatusOK, response)
+}
+
+// releaseLoginAttempt снимает засчитанную попытку входа по паролю.
+func (h *Handler) releaseLoginAttempt(ctx context.Context, userKey, clientKey string) error {
+	if err := h.Guards.Account.Release(ctx, userKey); err != nil {
+		return err
+	}
+	return h.Guards.IP.Release(ctx, clientKey)
+}
+
+func (h *Handler) ChangePassword(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.ValidPassword(req.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": models.PasswordRequirements})
+		return
+	}
+
+	var passwordHash string
+	err := h.DB.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&passwordHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный текущий пароль"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка хеширования пароля"})
+		return
+	}
+
+	_, err = h.DB.Exec(
+		"UPDATE users SET password_hash = $1, must_change_password = FALSE WHERE id = $2",
+		hashedPassword, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка смены пароля"})
+		return
+	}
+
+	_, err = h.DB.Exec(
+		"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, currentSessionID(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменён"})
+}
+
+func (h *Handler) userExists(username, email string) (bool, error) {
+	var exists bool
+	err := h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)",
+		username, email).Scan(&exists)
+	return exists, err
 }
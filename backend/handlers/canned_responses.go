@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"backend/models"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const cannedTitleMaxLength = 100
+
+// Подстановки в тексте шаблона. Значения, которые не удалось определить,
+// остаются в тексте как есть, чтобы сотрудник заполнил их вручную.
+const (
+	placeholderCitizenName    = "{citizen_name}"
+	placeholderOfficerName    = "{officer_name}"
+	placeholderStationName    = "{station_name}"
+	placeholderStationAddress = "{station_address}"
+	placeholderStationPhone   = "{station_phone}"
+)
+
+func (h *Handler) GetCannedResponses(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, owner_id, title, body, created_at, updated_at
+		FROM canned_responses
+		WHERE owner_id IS NULL OR owner_id = $1
+		ORDER BY owner_id NULLS FIRST, title
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения шаблонов"})
+		return
+	}
+	defer rows.Close()
+
+	responses := []models.CannedResponse{}
+	for rows.Next() {
+		var r models.CannedResponse
+		if err := rows.Scan(&r.ID, &r.OwnerID, &r.Title, &r.Body, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения шаблона"})
+			return
+		}
+		responses = append(responses, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения шаблонов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+// bindCannedResponse разбирает тело запроса и проверяет, что пользователь
+// может создавать шаблоны такого вида.
+func (h *Handler) bindCannedResponse(c *gin.Context) (models.CannedResponseRequest, bool) {
+	var req models.CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Название и текст обязательны"})
+		return req, false
+	}
+	if utf8.RuneCountInString(req.Title) > cannedTitleMaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Название длиннее %d символов", cannedTitleMaxLength)})
+		return req, false
+	}
+	if utf8.RuneCountInString(req.Body) > h.Config.Chat.MaxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Текст длиннее %d символов", h.Config.Chat.MaxMessageLength)})
+		return req, false
+	}
+	if req.Shared != nil && *req.Shared && !models.RoleHasPermission(c.GetString("role"), models.PermissionChatModerate) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Общие шаблоны может менять только старший смены"})
+		return req, false
+	}
+	return req, true
+}
+
+func (h *Handler) CreateCannedResponse(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	req, ok := h.bindCannedResponse(c)
+	if !ok {
+		return
+	}
+
+	response := models.CannedResponse{Title: req.Title, Body: req.Body}
+	if req.Shared == nil || !*req.Shared {
+		response.OwnerID = &userID
+	}
+	err := h.DB.QueryRow(`
+		INSERT INTO canned_responses (owner_id, title, body, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`, response.OwnerID, response.Title, response.Body, userID).Scan(&response.ID, &response.CreatedAt, &response.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания шаблона"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// editableCannedResponse находит шаблон из параметра response_id, который
+// пользователь может изменить: свой или общий, если он модератор.
+func (h *Handler) editableCannedResponse(c *gin.Context) (uuid.UUID, bool) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return uuid.Nil, false
+	}
+
+	responseID, err := uuid.Parse(c.Param("response_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID шаблона"})
+		return uuid.Nil, false
+	}
+
+	var ownerID *uuid.UUID
+	err = h.DB.QueryRow("SELECT owner_id FROM canned_responses WHERE id = $1", responseID).Scan(&ownerID)
+	if err == sql.ErrNoRows || (err == nil && ownerID != nil && *ownerID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Шаблон не найден"})
+		return uuid.Nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения шаблона"})
+		return uuid.Nil, false
+	}
+	if ownerID == nil && !models.RoleHasPermission(role, models.PermissionChatModerate) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Общие шаблоны может менять только старший смены"})
+		return uuid.Nil, false
+	}
+
+	return responseID, true
+}
+
+func (h *Handler) UpdateCannedResponse(c *gin.Context) {
+	responseID, ok := h.editableCannedResponse(c)
+	if !ok {
+		return
+	}
+	userID, _, _ := currentUser(c)
+
+	req, ok := h.bindCannedResponse(c)
+	if !ok {
+		return
+	}
+
+	// Без shared владелец не меняется, иначе модератор, поправивший текст
+	// общего шаблона, сделал бы его своим личным.
+	var ownerID *uuid.UUID
+	if req.Shared != nil && !*req.Shared {
+		ownerID = &userID
+	}
+
+	response := models.CannedResponse{ID: responseID, Title: req.Title, Body: req.Body}
+	err := h.DB.QueryRow(`
+		UPDATE canned_responses
+		SET owner_id = CASE WHEN $5 THEN $2 ELSE owner_id END, title = $3, body = $4, updated_at = NOW()
+		WHERE id = $1
+		RETURNING owner_id, created_at, updated_at
+	`, responseID, ownerID, response.Title, response.Body, req.Shared != nil).Scan(
+		&response.OwnerID, &response.CreatedAt, &response.UpdatedAt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления шаблона"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) DeleteCannedResponse(c *gin.Context) {
+	responseID, ok := h.editableCannedResponse(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec("DELETE FROM canned_responses WHERE id = $1", responseID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления шаблона"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Шаблон удалён"})
+}
+
+// RenderCannedResponse подставляет в шаблон имя гражданина из беседы
+// conversation_id, имя сотрудника и ближайший к latitude/longitude участок.
+func (h *Handler) RenderCannedResponse(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	responseID, err := uuid.Parse(c.Param("response_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID шаблона"})
+		return
+	}
+
+	var body string
+	err = h.DB.QueryRow(
+		"SELECT body FROM canned_responses WHERE id = $1 AND (owner_id IS NULL OR owner_id = $2)",
+		responseID, userID,
+	).Scan(&body)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Шаблон не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения шаблона"})
+		return
+	}
+
+	replacements := []string{placeholderOfficerName, c.GetString("username")}
+
+	if raw := c.Query("conversation_id"); raw != "" {
+		conversationID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID беседы"})
+			return
+		}
+		if _, err := h.conversationAccess(conversationID, userID, role); err != nil {
+			if err == errConversationNotFound || err == errConversationForbidden {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Беседа не найдена"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения беседы"})
+			return
+		}
+
+		var citizenName sql.NullString
+		err = h.DB.QueryRow(`
+			SELECT u.username FROM conversations c
+			LEFT JOIN users u ON u.id = c.citizen_id
+			WHERE c.id = $1
+		`, conversationID).Scan(&citizenName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения беседы"})
+			return
+		}
+		if citizenName.Valid {
+			replacements = append(replacements, placeholderCitizenName, citizenName.String)
+		}
+	}
+
+	if latStr, lonStr := c.Query("latitude"), c.Query("longitude"); latStr != "" && lonStr != "" {
+		lat, err := strconv.ParseFloat(latStr, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат широты"})
+			return
+		}
+		lon, err := strconv.ParseFloat(lonStr, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат долготы"})
+			return
+		}
+
+		station, _ := nearestPoliceStation(lat, lon)
+		replacements = append(replacements,
+			placeholderStationName, station.Name,
+			placeholderStationAddress, station.Address,
+			placeholderStationPhone, station.Phone,
+		)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"text": strings.NewReplacer(replacements...).Replace(body)})
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"backend/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const chatMaxNoteLength = 1000
+
+var (
+	errConversationNotAssignable = errors.New("conversation cannot be assigned")
+	errConversationAssigned      = errors.New("conversation already assigned")
+	errTransferForbidden         = errors.New("conversation assigned to another officer")
+)
+
+// conversationAudience возвращает, кому доставлять события беседы. Для
+// обращений гражданина действует canSeeDepartmentConversation: назначенное
+// видят гражданин, ответственный и модераторы, неназначенное — все
+// сотрудники, пока его кто-нибудь не возьмёт.
+func (h *Handler) conversationAudience(conversationID uuid.UUID) (func(userID uuid.UUID, role string) bool, error) {
+	var kind string
+	var citizenID, assignedTo *uuid.UUID
+	err := h.DB.QueryRow(
+		"SELECT kind, citizen_id, assigned_to FROM conversations WHERE id = $1",
+		conversationID,
+	).Scan(&kind, &citizenID, &assignedTo)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := h.conversationMemberIDs(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if kind == models.ConversationDepartment {
+		return func(userID uuid.UUID, role string) bool {
+			return canSeeDepartmentConversation(userID, role, citizenID, assignedTo, members[userID])
+		}, nil
+	}
+	return func(userID uuid.UUID, role string) bool {
+		return members[userID]
+	}, nil
+}
+
+// assignConversation делает toUserID ответственным за обращение. check
+// получает текущего ответственного и может запретить передачу.
+func (h *Handler) assignConversation(conversationID, toUserID uuid.UUID, by *uuid.UUID, note string, check func(current *uuid.UUID) error) (*models.ConversationTransfer, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var kind string
+	var current *uuid.UUID
+	err = tx.QueryRow(
+		"SELECT kind, assigned_to FROM conversations WHERE id = $1 FOR UPDATE",
+		conversationID,
+	).Scan(&kind, &current)
+	if err == sql.ErrNoRows {
+		return nil, errConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kind != models.ConversationDepartment {
+		return nil, errConversationNotAssignable
+	}
+	if err := check(current); err != nil {
+		return nil, err
+	}
+	if current != nil && *current == toUserID {
+		return nil, errConversationAssigned
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE conversations SET assigned_to = $2, assigned_at = NOW() WHERE id = $1",
+		conversationID, toUserID,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO conversation_members (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		conversationID, toUserID,
+	); err != nil {
+		return nil, err
+	}
+
+	transfer := models.ConversationTransfer{
+		ConversationID: conversationID,
+		FromUserID:     current,
+		ToUserID:       &toUserID,
+		TransferredBy:  by,
+		Note:           note,
+	}
+	var noteValue *string
+	if note != "" {
+		noteValue = &note
+	}
+	err = tx.QueryRow(`
+		INSERT INTO conversation_transfers (conversation_id, from_user_id, to_user_id, transferred_by, note)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, conversationID, current, toUserID, by, noteValue).Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	h.publishChatEvent(chatEvent{Type: chatEventAssigned, ConversationID: conversationID, TransferID: transfer.ID})
+	return &transfer, nil
+}
+
+// availableOfficer выбирает сотрудника в сети с наименьшим числом
+// назначенных обращений.
+func (h *Handler) availableOfficer() (uuid.UUID, bool, error) {
+	var officerID uuid.UUID
+	err := h.DB.QueryRow(`
+		SELECT u.id
+		FROM users u
+		JOIN chat_connections cc ON cc.user_id = u.id
+		WHERE u.role = ANY($1) AND u.disabled_at IS NULL
+			AND cc.status = $2 AND cc.heartbeat_at > NOW() - $3 * INTERVAL '1 second'
+		GROUP BY u.id
+		ORDER BY (SELECT COUNT(*) FROM conversations c WHERE c.assigned_to = u.id), MIN(cc.connected_at)
+		LIMIT 1
+	`, pq.Array(models.RolesWithPermission(models.PermissionChatHistory)), models.PresenceOnline,
+		int(wsPresenceTTL.Seconds())).Scan(&officerID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, false, nil
+	}
+	return officerID, err == nil, err
+}
+
+// assignOnMessage назначает ответственного за обращение при первом
+// сообщении: сотрудник, ответивший первым, берёт обращение себе, а
+// обращение гражданина отдаётся свободному сотруднику в сети. Если в сети
+// никого нет, обращение видят все сотрудники.
+func (h *Handler) assignOnMessage(conversationID, senderID uuid.UUID, role string) {
+	var assigned bool
+	err := h.DB.QueryRow(
+		"SELECT assigned_to IS NOT NULL FROM conversations WHERE id = $1",
+		conversationID,
+	).Scan(&assigned)
+	if err != nil || assigned {
+		if err != nil {
+			log.Printf("Ошибка получения беседы %s: %v", conversationID, err)
+		}
+		return
+	}
+
+	officerID := senderID
+	if !isDepartmentStaff(role) {
+		var ok bool
+		officerID, ok, err = h.availableOfficer()
+		if err != nil {
+			log.Printf("Ошибка выбора сотрудника для обращения %s: %v", conversationID, err)
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+
+	_, err = h.assignConversation(conversationID, officerID, nil, "", func(current *uuid.UUID) error {
+		if current != nil {
+			return errConversationAssigned
+		}
+		return nil
+	})
+	if err != nil && err != errConversationAssigned {
+		log.Printf("Ошибка назначения обращения %s: %v", conversationID, err)
+	}
+}
+
+// TransferConversation передаёт обращение гражданина другому сотруднику с
+// запиской. Передать может текущий ответственный или модератор; никому не
+// назначенное обращение может взять любой сотрудник.
+func (h *Handler) TransferConversation(c *gin.Context) {
+	conversationID, ok := h.accessibleConversation(c)
+	if !ok {
+		return
+	}
+	userID, role, _ := currentUser(c)
+
+	var req models.TransferConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан сотрудник"})
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if utf8.RuneCountInString(req.Note) > chatMaxNoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Записка длиннее %d символов", chatMaxNoteLength)})
+		return
+	}
+
+	targetRole, err := h.userRole(req.UserID)
+	if err == sql.ErrNoRows || (err == nil && !isDepartmentStaff(targetRole)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Обращение можно передать только сотруднику"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	moderator := models.RoleHasPermission(role, models.PermissionChatModerate)
+	transfer, err := h.assignConversation(conversationID, req.UserID, &userID, req.Note, func(current *uuid.UUID) error {
+		if current != nil && *current != userID && !moderator {
+			return errTransferForbidden
+		}
+		return nil
+	})
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, transfer)
+	case errConversationNotAssignable:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Назначать можно только обращения граждан"})
+	case errConversationAssigned:
+		c.JSON(http.StatusConflict, gin.H{"error": "Обращение уже ведёт этот сотрудник"})
+	case errTransferForbidden:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Обращение ведёт другой сотрудник"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка передачи обращения"})
+	}
+}
+
+// GetConversationTransfers возвращает историю назначений обращения.
+func (h *Handler) GetConversationTransfers(c *gin.Context) {
+	conversationID, ok := h.accessibleConversation(c)
+	if !ok {
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, from_user_id, to_user_id, transferred_by, COALESCE(note, ''), created_at
+		FROM conversation_transfers
+		WHERE conversation_id = $1
+		ORDER BY created_at, id
+	`, conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории назначений"})
+		return
+	}
+	defer rows.Close()
+
+	transfers := []models.ConversationTransfer{}
+	for rows.Next() {
+		transfer := models.ConversationTransfer{ConversationID: conversationID}
+		if err := rows.Scan(
+			&transfer.ID, &transfer.FromUserID, &transfer.ToUserID, &transfer.TransferredBy, &transfer.Note, &transfer.CreatedAt,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения истории назначений"})
+			return
+		}
+		transfers = append(transfers, transfer)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории назначений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfers)
+}
+
+// deliverAssignment сообщает новому и прежнему ответственному о передаче
+// обращения.
+func (h *Handler) deliverAssignment(event chatEvent) {
+	transfer := models.ConversationTransfer{ID: event.TransferID, ConversationID: event.ConversationID}
+	err := h.DB.QueryRow(`
+		SELECT from_user_id, to_user_id, transferred_by, COALESCE(note, ''), created_at
+		FROM conversation_transfers
+		WHERE id = $1
+	`, event.TransferID).Scan(&transfer.FromUserID, &transfer.ToUserID, &transfer.TransferredBy, &transfer.Note, &transfer.CreatedAt)
+	if err != nil {
+		log.Printf("Ошибка загрузки назначения %s: %v", event.TransferID, err)
+		return
+	}
+
+	data, err := encodeFrame(wsTypeChatAssigned, transfer)
+	if err != nil {
+		log.Printf("Ошибка сериализации назначения: %v", err)
+		return
+	}
+
+	h.Hub.Broadcast(data, func(client *WebSocketClient) bool {
+		if client.UserID == nil {
+			return false
+		}
+		return (transfer.ToUserID != nil && *client.UserID == *transfer.ToUserID) ||
+			(transfer.FromUserID != nil && *client.UserID == *transfer.FromUserID)
+	})
+}
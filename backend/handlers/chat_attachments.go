@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"backend/models"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// chatMaxAttachments — сколько вложений можно отправить одним сообщением.
+	chatMaxAttachments = 10
+	// chatMaxVoiceDuration — наибольшая длительность голосового сообщения.
+	chatMaxVoiceDuration  = 30 * 60 * 1000
+	chatMaxFileNameLength = 255
+)
+
+var errAttachmentNotFound = errors.New("chat attachment not found")
+
+// attachmentKind относит MIME-тип к виду вложения. Браузеры записывают
+// голосовые сообщения в WebM или Ogg, и сниффер видит их как video/webm и
+// application/ogg.
+func attachmentKind(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return models.AttachmentImage
+	case strings.HasPrefix(mimeType, "audio/"), mimeType == "application/ogg", mimeType == "video/webm":
+		return models.AttachmentVoice
+	default:
+		return models.AttachmentDocument
+	}
+}
+
+func attachmentURL(id uuid.UUID) string {
+	return "/api/chat/attachments/" + id.String()
+}
+
+func (h *Handler) attachmentPath(id uuid.UUID) string {
+	return filepath.Join(h.Config.Chat.AttachmentsDir, id.String())
+}
+
+func (h *Handler) attachmentTypeAllowed(mimeType string) bool {
+	for _, allowed := range h.Config.Chat.AttachmentTypes {
+		if allowed == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadChatAttachment сохраняет файл из поля file формы. Вложение
+// прикрепляется к сообщению через attachment_ids в chat.send.
+func (h *Handler) UploadChatAttachment(c *gin.Context) {
+	conversationID, ok := h.accessibleConversation(c)
+	if !ok {
+		return
+	}
+	userID, _, _ := currentUser(c)
+
+	maxBytes := h.Config.Chat.MaxAttachmentBytes
+	// Запас на заголовки и остальные поля формы.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не передан или слишком большой"})
+		return
+	}
+	if header.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Файл больше " + strconv.FormatInt(maxBytes, 10) + " байт"})
+		return
+	}
+
+	fileName := filepath.Base(header.Filename)
+	if fileName == "." || fileName == string(filepath.Separator) || utf8.RuneCountInString(fileName) > chatMaxFileNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверное имя файла"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка чтения файла"})
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка чтения файла"})
+		return
+	}
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if !h.attachmentTypeAllowed(mimeType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Недопустимый тип файла: " + mimeType})
+		return
+	}
+
+	attachment := models.ChatAttachment{
+		ID:       uuid.New(),
+		Kind:     attachmentKind(mimeType),
+		FileName: fileName,
+		MimeType: mimeType,
+		Size:     header.Size,
+	}
+
+	if raw := c.PostForm("duration_ms"); raw != "" {
+		duration, err := strconv.Atoi(raw)
+		if err != nil || duration <= 0 || duration > chatMaxVoiceDuration || attachment.Kind != models.AttachmentVoice {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверная длительность"})
+			return
+		}
+		attachment.DurationMs = &duration
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения файла"})
+		return
+	}
+	if attachment.Kind == models.AttachmentImage {
+		if cfg, _, err := image.DecodeConfig(file); err == nil {
+			attachment.Width, attachment.Height = &cfg.Width, &cfg.Height
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения файла"})
+			return
+		}
+	}
+
+	checksum, err := h.storeAttachment(attachment.ID, file)
+	if err != nil {
+		log.Printf("Ошибка сохранения вложения: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения файла"})
+		return
+	}
+	attachment.Checksum = checksum
+
+	err = h.DB.QueryRow(`
+		INSERT INTO chat_attachments
+			(id, conversation_id, uploader_id, kind, file_name, mime_type, size, checksum, width, height, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING created_at
+	`, attachment.ID, conversationID, userID, attachment.Kind, attachment.FileName, attachment.MimeType,
+		attachment.Size, attachment.Checksum, attachment.Width, attachment.Height, attachment.DurationMs,
+	).Scan(&attachment.CreatedAt)
+	if err != nil {
+		os.Remove(h.attachmentPath(attachment.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения вложения"})
+		return
+	}
+
+	attachment.URL = attachmentURL(attachment.ID)
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// storeAttachment записывает файл в каталог вложений и возвращает SHA-256
+// содержимого.
+func (h *Handler) storeAttachment(id uuid.UUID, src io.Reader) (string, error) {
+	if err := os.MkdirAll(h.Config.Chat.AttachmentsDir, 0o750); err != nil {
+		return "", err
+	}
+
+	path := h.attachmentPath(id)
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// GetChatAttachment отдаёт вложение участникам беседы. Неотправленное
+// вложение доступно только загрузившему его, вложение удалённого сообщения —
+// никому.
+func (h *Handler) GetChatAttachment(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	attachmentID, err := uuid.Parse(c.Param("attachment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID вложения"})
+		return
+	}
+
+	var conversationID uuid.UUID
+	var messageID, uploaderID *uuid.UUID
+	var fileName, mimeType string
+	var deleted bool
+	err = h.DB.QueryRow(`
+		SELECT a.conversation_id, a.message_id, a.uploader_id, a.file_name, a.mime_type, m.deleted_at IS NOT NULL
+		FROM chat_attachments a
+		LEFT JOIN chat_messages m ON m.id = a.message_id
+		WHERE a.id = $1
+	`, attachmentID).Scan(&conversationID, &messageID, &uploaderID, &fileName, &mimeType, &deleted)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Вложение не найдено"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вложения"})
+		return
+	}
+
+	if deleted || (messageID == nil && (uploaderID == nil || *uploaderID != userID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Вложение не найдено"})
+		return
+	}
+	if _, err := h.conversationAccess(conversationID, userID, role); err != nil {
+		if err == errConversationNotFound || err == errConversationForbidden {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Вложение не найдено"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вложения"})
+		return
+	}
+
+	disposition := "attachment"
+	if attachmentKind(mimeType) == models.AttachmentImage {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", mimeType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=86400")
+	http.ServeFile(c.Writer, c.Request, h.attachmentPath(attachmentID))
+}
+
+// attachToMessage привязывает загруженные отправителем вложения беседы к
+// сообщению. Если хотя бы одно не найдено или уже отправлено, возвращает
+// errAttachmentNotFound.
+func attachToMessage(tx *sql.Tx, messageID, conversationID, senderID uuid.UUID, attachmentIDs []uuid.UUID) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	result, err := tx.Exec(`
+		UPDATE chat_attachments SET message_id = $1
+		WHERE id = ANY($2) AND conversation_id = $3 AND uploader_id = $4 AND message_id IS NULL
+	`, messageID, pq.Array(attachmentIDs), conversationID, senderID)
+	if err != nil {
+		return err
+	}
+
+	attached, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if attached != int64(len(attachmentIDs)) {
+		return errAttachmentNotFound
+	}
+	return nil
+}
+
+// messageAttachments загружает вложения сообщений. У удалённых сообщений
+// вложений нет.
+func (h *Handler) messageAttachments(messageIDs []uuid.UUID) (map[uuid.UUID][]models.ChatAttachment, error) {
+	result := make(map[uuid.UUID][]models.ChatAttachment)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT a.message_id, a.id, a.kind, a.file_name, a.mime_type, a.size, a.checksum,
+			a.width, a.height, a.duration_ms, a.created_at
+		FROM chat_attachments a
+		JOIN chat_messages m ON m.id = a.message_id
+		WHERE a.message_id = ANY($1) AND m.deleted_at IS NULL
+		ORDER BY a.created_at, a.id
+	`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var a models.ChatAttachment
+		if err := rows.Scan(
+			&messageID, &a.ID, &a.Kind, &a.FileName, &a.MimeType, &a.Size, &a.Checksum,
+			&a.Width, &a.Height, &a.DurationMs, &a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		a.URL = attachmentURL(a.ID)
+		result[messageID] = append(result[messageID], a)
+	}
+
+	return result, rows.Err()
+}
+
+// loadAttachments заполняет Attachments у сообщений.
+func (h *Handler) loadAttachments(messages []models.ChatMessage) error {
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	attachments, err := h.messageAttachments(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return nil
+}
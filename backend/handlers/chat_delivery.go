@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	receiptDelivered = "delivered"
+	receiptRead      = "read"
+)
+
+// wsReplayLimit — сколько пропущенных сообщений отдаётся за один раз.
+// Остальные клиент дозапрашивает кадром chat.replay с курсором из
+// chat.replay_done.
+const wsReplayLimit = 100
+
+// reply отправляет кадр только этому клиенту. В отличие от Send, ждёт места
+// в очереди: его вызывает читающая горутина самого клиента, и ожидание лишь
+// замедляет чтение его же кадров.
+func (c *WebSocketClient) reply(frameType string, payload interface{}) {
+	data, err := encodeFrame(frameType, payload)
+	if err != nil {
+		log.Printf("Ошибка сериализации кадра: %v", err)
+		return
+	}
+
+	select {
+	case c.send <- data:
+	case <-c.closing:
+	}
+}
+
+// replyError сообщает клиенту, что кадр requestID не обработан.
+func (c *WebSocketClient) replyError(requestID string, e *wsError) {
+	c.reply(wsTypeError, errorPayload{RequestID: requestID, Code: e.Code, Message: e.Message})
+}
+
+func internalError(message string) *wsError {
+	return &wsError{Code: wsErrInternal, Message: message}
+}
+
+// handleChatSend сохраняет сообщение из кадра chat.send и подтверждает его
+// отправителю.
+func (h *Handler) handleChatSend(client *WebSocketClient, requestID string, p chatSendPayload) {
+	message, err := h.sendChatMessage(*client.UserID, client.Role, p, client.ID)
+	if err == errConversationNotFound || err == errConversationForbidden {
+		client.replyError(requestID, &wsError{Code: wsErrNotFound, Message: "Беседа не найдена"})
+		return
+	}
+	if err == errAttachmentNotFound {
+		client.replyError(requestID, &wsError{Code: wsErrNotFound, Message: "Вложение не найдено"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения сообщения: %v", err)
+		client.replyError(requestID, internalError("Ошибка сохранения сообщения"))
+		return
+	}
+
+	client.reply(wsTypeChatAck, ackPayload(message))
+}
+
+// sendChatMessage сохраняет сообщение и рассылает его участникам беседы,
+// кроме соединения skipClient. Повторная отправка с тем же
+// client_message_id возвращает уже сохранённое сообщение и не рассылается.
+func (h *Handler) sendChatMessage(senderID uuid.UUID, role string, p chatSendPayload, skipClient uuid.UUID) (*models.ChatMessage, error) {
+	existing, err := h.findClientMessage(senderID, p.ClientMessageID)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	conversationID, kind, err := h.resolveConversation(senderID, role, p.ConversationID, p.RecipientID)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedMessage, err := utils.Encrypt([]byte(p.Message), []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	clientMessageID := p.ClientMessageID
+	message := models.ChatMessage{
+		ConversationID:  &conversationID,
+		SenderID:        &senderID,
+		RecipientID:     p.RecipientID,
+		ClientMessageID: &clientMessageID,
+		Message:         p.Message,
+	}
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO chat_messages (conversation_id, sender_id, recipient_id, message, client_message_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sender_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
+		RETURNING id, created_at
+	`, conversationID, senderID, p.RecipientID, encryptedMessage, clientMessageID).Scan(&message.ID, &message.CreatedAt)
+	if err == sql.ErrNoRows {
+		// Та же отправка пришла параллельно через другое соединение.
+		tx.Rollback()
+		existing, err := h.findClientMessage(senderID, clientMessageID)
+		if err == nil && existing == nil {
+			err = errors.New("chat message disappeared after conflict")
+		}
+		return existing, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachToMessage(tx, message.ID, conversationID, senderID, p.AttachmentIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	messages := []models.ChatMessage{message}
+	if err := h.loadAttachments(messages); err != nil {
+		return nil, err
+	}
+	message = messages[0]
+
+	if kind == models.ConversationDepartment {
+		h.assignOnMessage(conversationID, senderID, role)
+	}
+
+	h.publishChatMessage(message.ID, skipClient)
+	return &message, nil
+}
+
+func ackPayload(message *models.ChatMessage) chatAckPayload {
+	return chatAckPayload{
+		ClientMessageID: message.ClientMessageID,
+		Message:         message,
+		Cursor:          encodeCursor(message.CreatedAt, message.ID),
+	}
+}
+
+func (h *Handler) findClientMessage(senderID, clientMessageID uuid.UUID) (*models.ChatMessage, error) {
+	var message models.ChatMessage
+	var encryptedMessage string
+	err := h.DB.QueryRow(`
+		SELECT id, conversation_id, sender_id, recipient_id, client_message_id, message, created_at,
+			edited_at, deleted_at, redacted
+		FROM chat_messages
+		WHERE sender_id = $1 AND client_message_id = $2
+	`, senderID, clientMessageID).Scan(
+		&message.ID, &message.ConversationID, &message.SenderID, &message.RecipientID,
+		&message.ClientMessageID, &encryptedMessage, &message.CreatedAt,
+		&message.EditedAt, &message.DeletedAt, &message.Redacted,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return nil, err
+	}
+	message.Message = string(text)
+
+	messages := []models.ChatMessage{message}
+	if err := h.loadAttachments(messages); err != nil {
+		return nil, err
+	}
+
+	return &messages[0], nil
+}
+
+// markDelivered отмечает сообщения полученными пользователем. Чужие и
+// недоступные ему сообщения пропускаются.
+func (h *Handler) markDelivered(userID uuid.UUID, role string, messageIDs []uuid.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	rows, err := h.DB.Query(`
+		WITH marked AS (
+			INSERT INTO chat_message_receipts (message_id, user_id, delivered_at)
+			SELECT cm.id, $2, NOW()
+			FROM chat_messages cm
+			JOIN conversations c ON c.id = cm.conversation_id
+			WHERE cm.id = ANY($1) AND cm.sender_id IS DISTINCT FROM $2
+				AND `+conversationVisibleSQL("$2", "$3", "$4")+`
+			ON CONFLICT (message_id, user_id) DO NOTHING
+			RETURNING message_id, delivered_at
+		)
+		SELECT k.message_id, cm.sender_id, k.delivered_at
+		FROM marked k
+		JOIN chat_messages cm ON cm.id = k.message_id
+		WHERE cm.sender_id IS NOT NULL
+	`, pq.Array(messageIDs), userID, isDepartmentStaff(role), models.RoleHasPermission(role, models.PermissionChatModerate))
+	if err != nil {
+		return err
+	}
+
+	receipts, at, err := scanReceipts(rows)
+	if err != nil {
+		return err
+	}
+
+	h.publishReceipts(userID, receiptDelivered, receipts, at)
+	return nil
+}
+
+// markConversationRead отмечает прочитанными все сообщения беседы, пришедшие
+// после предыдущего прочтения, и уведомляет их отправителей.
+func (h *Handler) markConversationRead(conversationID, userID uuid.UUID) error {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		WITH marked AS (
+			INSERT INTO chat_message_receipts (message_id, user_id, delivered_at, read_at)
+			SELECT cm.id, $2, NOW(), NOW()
+			FROM chat_messages cm
+			LEFT JOIN conversation_members m ON m.conversation_id = cm.conversation_id AND m.user_id = $2
+			WHERE cm.conversation_id = $1 AND cm.sender_id IS DISTINCT FROM $2
+				AND cm.created_at > COALESCE(m.last_read_at, '-infinity'::timestamp)
+			ON CONFLICT (message_id, user_id) DO UPDATE
+				SET read_at = EXCLUDED.read_at,
+					delivered_at = COALESCE(chat_message_receipts.delivered_at, EXCLUDED.delivered_at)
+				WHERE chat_message_receipts.read_at IS NULL
+			RETURNING message_id, read_at
+		)
+		SELECT k.message_id, cm.sender_id, k.read_at
+		FROM marked k
+		JOIN chat_messages cm ON cm.id = k.message_id
+		WHERE cm.sender_id IS NOT NULL
+	`, conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	receipts, at, err := scanReceipts(rows)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE conversation_members SET last_read_at = NOW() WHERE conversation_id = $1 AND user_id = $2",
+		conversationID, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	h.publishReceipts(userID, receiptRead, receipts, at)
+	return nil
+}
+
+func scanReceipts(rows *sql.Rows) (map[uuid.UUID]uuid.UUID, time.Time, error) {
+	defer rows.Close()
+
+	receipts := make(map[uuid.UUID]uuid.UUID)
+	var at time.Time
+	for rows.Next() {
+		var messageID, senderID uuid.UUID
+		if err := rows.Scan(&messageID, &senderID, &at); err != nil {
+			return nil, time.Time{}, err
+		}
+		receipts[messageID] = senderID
+	}
+
+	return receipts, at, rows.Err()
+}
+
+// messageReceipts загружает отметки о доставке для сообщений.
+func (h *Handler) messageReceipts(messageIDs []uuid.UUID) (map[uuid.UUID][]models.MessageReceipt, error) {
+	result := make(map[uuid.UUID][]models.MessageReceipt)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT message_id, user_id, delivered_at, read_at
+		FROM chat_message_receipts
+		WHERE message_id = ANY($1)
+	`, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var receipt models.MessageReceipt
+		if err := rows.Scan(&messageID, &receipt.UserID, &receipt.DeliveredAt, &receipt.ReadAt); err != nil {
+			return nil, err
+		}
+		result[messageID] = append(result[messageID], receipt)
+	}
+
+	return result, rows.Err()
+}
+
+// replayMessages отправляет клиенту чужие сообщения из доступных ему бесед,
+// созданные после курсора. Сообщения, пришедшие одновременно по шине, могут
+// повториться — клиент отбрасывает их по id.
+func (h *Handler) replayMessages(client *WebSocketClient, requestID, cursor string) {
+	since, sinceID, err := decodeCursor(cursor)
+	if err != nil {
+		client.replyError(requestID, &wsError{Code: wsErrInvalidPayload, Message: "Неверный курсор"})
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT cm.id, cm.conversation_id, cm.sender_id, cm.recipient_id, cm.client_message_id, cm.message, cm.created_at,
+			cm.edited_at, cm.deleted_at, cm.redacted
+		FROM chat_messages cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		WHERE (cm.created_at, cm.id) > ($2::timestamp, $3::uuid)
+			AND cm.sender_id IS DISTINCT FROM $1
+			AND `+conversationVisibleSQL("$1", "$4", "$6")+`
+		ORDER BY cm.created_at, cm.id
+		LIMIT $5
+	`, client.UserID, since, sinceID, isDepartmentStaff(client.Role), wsReplayLimit+1,
+		models.RoleHasPermission(client.Role, models.PermissionChatModerate))
+	if err != nil {
+		log.Printf("Ошибка получения пропущенных сообщений: %v", err)
+		client.replyError(requestID, internalError("Ошибка получения пропущенных сообщений"))
+		return
+	}
+	defer rows.Close()
+
+	var messages []models.ChatMessage
+	for rows.Next() {
+		var message models.ChatMessage
+		var encryptedMessage string
+		if err := rows.Scan(
+			&message.ID, &message.ConversationID, &message.SenderID, &message.RecipientID,
+			&message.ClientMessageID, &encryptedMessage, &message.CreatedAt,
+			&message.EditedAt, &message.DeletedAt, &message.Redacted,
+		); err != nil {
+			log.Printf("Ошибка чтения пропущенного сообщения: %v", err)
+			client.replyError(requestID, internalError("Ошибка получения пропущенных сообщений"))
+			return
+		}
+
+		text, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+		if err != nil {
+			log.Printf("Ошибка расшифровки сообщения %s: %v", message.ID, err)
+			client.replyError(requestID, internalError("Ошибка получения пропущенных сообщений"))
+			return
+		}
+		message.Message = string(text)
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Ошибка получения пропущенных сообщений: %v", err)
+		client.replyError(requestID, internalError("Ошибка получения пропущенных сообщений"))
+		return
+	}
+
+	hasMore := len(messages) > wsReplayLimit
+	if hasMore {
+		messages = messages[:wsReplayLimit]
+	}
+	if err := h.loadAttachments(messages); err != nil {
+		log.Printf("Ошибка получения вложений: %v", err)
+		client.replyError(requestID, internalError("Ошибка получения пропущенных сообщений"))
+		return
+	}
+
+	for i := range messages {
+		cursor = encodeCursor(messages[i].CreatedAt, messages[i].ID)
+		client.reply(wsTypeChatMessage, chatMessagePayload{Message: &messages[i], Cursor: cursor})
+	}
+
+	client.reply(wsTypeChatReplayDone, chatReplayDonePayload{Cursor: cursor, HasMore: hasMore})
+}
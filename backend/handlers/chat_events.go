@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// chatChannel — канал шины, по которому экземпляры сервиса сообщают друг
+// другу о событиях чата.
+const chatChannel = "chat_events"
+
+const (
+	chatEventMessage  = "message"
+	chatEventEdited   = "edited"
+	chatEventDeleted  = "deleted"
+	chatEventReceipt  = "receipt"
+	chatEventPresence = "presence"
+	chatEventTyping   = "typing"
+	chatEventIncident = "incident"
+	chatEventAssigned = "assigned"
+)
+
+// receiptBatchSize ограничивает число идентификаторов в одном событии,
+// чтобы оно укладывалось в лимит NOTIFY.
+const receiptBatchSize = 100
+
+// chatEvent передаёт только ссылки на сохранённые данные: текст не покидает
+// базу в открытом виде, а размер события укладывается в лимит NOTIFY.
+type chatEvent struct {
+	Type      string    `json:"type"`
+	MessageID uuid.UUID `json:"message_id,omitempty"`
+	// SkipClient — соединение отправителя, которому сообщение не пересылается.
+	SkipClient uuid.UUID `json:"skip_client,omitempty"`
+
+	// Поля отметки о доставке: кто (UserID) получил или прочитал сообщения
+	// MessageIDs, отправленные SenderID.
+	SenderID   uuid.UUID   `json:"sender_id,omitempty"`
+	UserID     uuid.UUID   `json:"user_id,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	MessageIDs []uuid.UUID `json:"message_ids,omitempty"`
+	At         time.Time   `json:"at,omitempty"`
+
+	// ConversationID — беседа, в которой UserID начал или закончил печатать
+	// (Status — start или stop). Для присутствия Status — новый статус UserID.
+	ConversationID uuid.UUID `json:"conversation_id,omitempty"`
+
+	// IncidentID — инцидент, который создан, изменён или получил сообщение
+	// MessageID. Status — created, updated или message.
+	IncidentID uuid.UUID `json:"incident_id,omitempty"`
+
+	// TransferID — передача обращения ConversationID другому сотруднику.
+	TransferID uuid.UUID `json:"transfer_id,omitempty"`
+}
+
+func (h *Handler) publishChatEvent(event chatEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Ошибка сериализации события чата: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Bus.Publish(ctx, chatChannel, payload); err != nil {
+		log.Printf("Ошибка публикации события чата: %v", err)
+	}
+}
+
+func (h *Handler) publishChatMessage(messageID, skipClient uuid.UUID) {
+	h.publishChatEvent(chatEvent{Type: chatEventMessage, MessageID: messageID, SkipClient: skipClient})
+}
+
+// publishReceipts сообщает отправителям, что userID получил или прочитал их
+// сообщения. receipts сопоставляет идентификатор сообщения с отправителем.
+func (h *Handler) publishReceipts(userID uuid.UUID, status string, receipts map[uuid.UUID]uuid.UUID, at time.Time) {
+	bySender := make(map[uuid.UUID][]uuid.UUID)
+	for messageID, senderID := range receipts {
+		bySender[senderID] = append(bySender[senderID], messageID)
+	}
+
+	for senderID, ids := range bySender {
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > receiptBatchSize {
+				n = receiptBatchSize
+			}
+			h.publishChatEvent(chatEvent{
+				Type:       chatEventReceipt,
+				SenderID:   senderID,
+				UserID:     userID,
+				Status:     status,
+				MessageIDs: ids[:n],
+				At:         at,
+			})
+			ids = ids[n:]
+		}
+	}
+}
+
+// deliverChatEvent вызывается шиной на каждом экземпляре и рассылает событие
+// подключённым к нему клиентам.
+func (h *Handler) deliverChatEvent(payload []byte) {
+	var event chatEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("Ошибка разбора события чата: %v", err)
+		return
+	}
+
+	switch event.Type {
+	case chatEventMessage, chatEventEdited, chatEventDeleted:
+		h.deliverChatMessage(event)
+	case chatEventReceipt:
+		h.deliverReceipt(event)
+	case chatEventPresence:
+		h.deliverPresence(event)
+	case chatEventTyping:
+		h.deliverTyping(event)
+	case chatEventIncident:
+		h.deliverIncident(event)
+	case chatEventAssigned:
+		h.deliverAssignment(event)
+	}
+}
+
+// loadChatMessage загружает сообщение с вложениями и вид его беседы.
+func (h *Handler) loadChatMessage(messageID uuid.UUID) (*models.ChatMessage, string, error) {
+	var message models.ChatMessage
+	var conversationID uuid.UUID
+	var kind, encryptedMessage string
+	err := h.DB.QueryRow(`
+		SELECT m.conversation_id, c.kind, m.sender_id, m.recipient_id, m.client_message_id, m.message, m.created_at,
+			m.edited_at, m.deleted_at, m.redacted
+		FROM chat_messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1
+	`, messageID).Scan(
+		&conversationID, &kind, &message.SenderID, &message.RecipientID,
+		&message.ClientMessageID, &encryptedMessage, &message.CreatedAt,
+		&message.EditedAt, &message.DeletedAt, &message.Redacted,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	text, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return nil, "", err
+	}
+
+	message.ID = messageID
+	message.ConversationID = &conversationID
+	message.Message = string(text)
+
+	attachments, err := h.messageAttachments([]uuid.UUID{message.ID})
+	if err != nil {
+		return nil, "", err
+	}
+	message.Attachments = attachments[message.ID]
+
+	return &message, kind, nil
+}
+
+// deliverChatMessage рассылает участникам беседы новое сообщение
+// (chat.message), исправленное (chat.edited) или заглушку удалённого
+// (chat.deleted).
+func (h *Handler) deliverChatMessage(event chatEvent) {
+	message, _, err := h.loadChatMessage(event.MessageID)
+	if err != nil {
+		log.Printf("Ошибка загрузки сообщения %s: %v", event.MessageID, err)
+		return
+	}
+
+	var frame []byte
+	switch event.Type {
+	case chatEventEdited:
+		frame, err = encodeFrame(wsTypeChatEdited, chatMessagePayload{
+			Message: message,
+			Cursor:  encodeCursor(message.CreatedAt, message.ID),
+		})
+	case chatEventDeleted:
+		frame, err = encodeFrame(wsTypeChatDeleted, chatDeletedPayload{
+			MessageID:      message.ID,
+			ConversationID: *message.ConversationID,
+			DeletedAt:      message.DeletedAt,
+			Redacted:       message.Redacted,
+		})
+	default:
+		frame, err = encodeFrame(wsTypeChatMessage, chatMessagePayload{
+			Message: message,
+			Cursor:  encodeCursor(message.CreatedAt, message.ID),
+		})
+	}
+	if err != nil {
+		log.Printf("Ошибка сериализации сообщения: %v", err)
+		return
+	}
+
+	audience, err := h.conversationAudience(*message.ConversationID)
+	if err != nil {
+		log.Printf("Ошибка получения участников беседы: %v", err)
+		return
+	}
+
+	h.Hub.Broadcast(frame, func(client *WebSocketClient) bool {
+		if client.ID == event.SkipClient || client.UserID == nil {
+			return false
+		}
+		return audience(*client.UserID, client.Role)
+	})
+}
+
+func (h *Handler) deliverReceipt(event chatEvent) {
+	frame, err := encodeFrame(wsTypeChatReceipt, chatReceiptPayload{
+		UserID:     event.UserID,
+		Status:     event.Status,
+		MessageIDs: event.MessageIDs,
+		At:         event.At,
+	})
+	if err != nil {
+		log.Printf("Ошибка сериализации отметки о доставке: %v", err)
+		return
+	}
+
+	h.Hub.Broadcast(frame, func(client *WebSocketClient) bool {
+		return client.UserID != nil && *client.UserID == event.SenderID
+	})
+}
+
+// deliverPresence сообщает сотрудникам об изменении статуса пользователя.
+func (h *Handler) deliverPresence(event chatEvent) {
+	payload := presenceEventPayload{UserID: event.UserID, Status: event.Status}
+	if !event.At.IsZero() {
+		payload.LastSeenAt = &event.At
+	}
+
+	data, err := encodeFrame(wsTypePresence, payload)
+	if err != nil {
+		log.Printf("Ошибка сериализации статуса: %v", err)
+		return
+	}
+
+	h.Hub.Broadcast(data, func(client *WebSocketClient) bool {
+		return isDepartmentStaff(client.Role)
+	})
+}
+
+// deliverTyping пересылает «печатает» остальным участникам беседы.
+func (h *Handler) deliverTyping(event chatEvent) {
+	audience, err := h.conversationAudience(event.ConversationID)
+	if err != nil {
+		log.Printf("Ошибка получения участников беседы %s: %v", event.ConversationID, err)
+		return
+	}
+
+	data, err := encodeFrame(wsTypeTyping, typingEventPayload{
+		ConversationID: event.ConversationID,
+		UserID:         event.UserID,
+		State:          event.Status,
+	})
+	if err != nil {
+		log.Printf("Ошибка сериализации события набора: %v", err)
+		return
+	}
+
+	h.Hub.Broadcast(data, func(client *WebSocketClient) bool {
+		if client.UserID == nil || *client.UserID == event.UserID {
+			return false
+		}
+		return audience(*client.UserID, client.Role)
+	})
+}
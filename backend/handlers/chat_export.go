@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	exportFormatJSON = "json"
+	exportFormatTXT  = "txt"
+	exportFormatPDF  = "pdf"
+
+	exportTimeLayout = "2006-01-02 15:04:05"
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatJSON: "application/json",
+	exportFormatTXT:  "text/plain; charset=utf-8",
+	exportFormatPDF:  "application/pdf",
+}
+
+type transcriptParticipant struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+}
+
+type transcriptMessage struct {
+	ID         uuid.UUID  `json:"id"`
+	SenderID   *uuid.UUID `json:"sender_id"`
+	SenderName string     `json:"sender_name"`
+	SentAt     string     `json:"sent_at"`
+	EditedAt   string     `json:"edited_at,omitempty"`
+	Deleted    bool       `json:"deleted,omitempty"`
+	Redacted   bool       `json:"redacted,omitempty"`
+	Message    string     `json:"message"`
+
+	Attachments []models.ChatAttachment `json:"attachments,omitempty"`
+}
+
+// transcript — выгрузка беседы. Время указано в часовом поясе Timezone.
+type transcript struct {
+	ConversationID uuid.UUID               `json:"conversation_id"`
+	Kind           string                  `json:"kind"`
+	Title          string                  `json:"title,omitempty"`
+	Timezone       string                  `json:"timezone"`
+	ExportedAt     string                  `json:"exported_at"`
+	ExportedBy     string                  `json:"exported_by"`
+	Participants   []transcriptParticipant `json:"participants"`
+	Messages       []transcriptMessage     `json:"messages"`
+}
+
+// ExportConversation выгружает всю переписку в json, txt или pdf. SHA-256
+// выгрузки возвращается в заголовке X-Content-SHA256 и сохраняется в
+// audit_log вместе с тем, кто её сделал, чтобы позже подтвердить, что файл
+// не менялся.
+//
+// Доступ проверяется без вступления в беседу, чтобы выгружающий не попадал
+// в список участников. Обращения в департамент выгружают те же сотрудники,
+// что могут их читать; личные и групповые беседы — только их участники,
+// для остальных, в том числе модераторов, беседа не найдена.
+func (h *Handler) ExportConversation(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID беседы"})
+		return
+	}
+
+	_, _, err = h.checkConversationAccess(conversationID, userID, role)
+	switch err {
+	case nil:
+	case errConversationNotFound, errConversationForbidden:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Беседа не найдена"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения беседы"})
+		return
+	}
+
+	format := c.DefaultQuery("format", exportFormatJSON)
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Формат должен быть pdf, json или txt"})
+		return
+	}
+
+	location, err := time.LoadLocation(h.Config.Chat.ExportTimezone)
+	if err != nil {
+		log.Printf("Неверный CHAT_EXPORT_TIMEZONE %q: %v", h.Config.Chat.ExportTimezone, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Неверный часовой пояс выгрузки"})
+		return
+	}
+
+	t, err := h.buildTranscript(conversationID, c.GetString("username"), location)
+	if err != nil {
+		log.Printf("Ошибка выгрузки беседы %s: %v", conversationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка выгрузки беседы"})
+		return
+	}
+
+	var data []byte
+	switch format {
+	case exportFormatJSON:
+		data, err = json.MarshalIndent(t, "", "  ")
+	case exportFormatTXT:
+		data = renderTranscriptText(t)
+	case exportFormatPDF:
+		data, err = renderTranscriptPDF(t, h.Config.Chat.ExportFont)
+	}
+	if err != nil {
+		log.Printf("Ошибка формирования выгрузки беседы %s: %v", conversationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования выгрузки"})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	err = writeAudit(h.DB, userID, "chat.export", "conversation", conversationID, gin.H{
+		"format":   format,
+		"sha256":   digest,
+		"messages": len(t.Messages),
+	})
+	if err != nil {
+		// Выгрузка без записи в журнале не отдаётся.
+		log.Printf("Ошибка записи в журнал аудита: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка записи в журнал аудита"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="conversation-%s.%s"`, conversationID, format))
+	c.Header("X-Content-SHA256", digest)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (h *Handler) buildTranscript(conversationID uuid.UUID, exportedBy string, location *time.Location) (*transcript, error) {
+	t := &transcript{
+		ConversationID: conversationID,
+		Timezone:       location.String(),
+		ExportedAt:     time.Now().In(location).Format(exportTimeLayout),
+		ExportedBy:     exportedBy,
+		Participants:   []transcriptParticipant{},
+		Messages:       []transcriptMessage{},
+	}
+
+	err := h.DB.QueryRow(
+		"SELECT kind, COALESCE(title, '') FROM conversations WHERE id = $1",
+		conversationID,
+	).Scan(&t.Kind, &t.Title)
+	if err != nil {
+		return nil, err
+	}
+
+	// Участники — члены беседы на момент выгрузки и все, кто в ней писал.
+	// Выгружающий попадает сюда, только если сам состоит в беседе или писал.
+	rows, err := h.DB.Query(`
+		SELECT u.id, u.username, u.role
+		FROM users u
+		WHERE u.id IN (SELECT user_id FROM conversation_members WHERE conversation_id = $1)
+			OR u.id IN (SELECT sender_id FROM chat_messages WHERE conversation_id = $1)
+		ORDER BY u.username
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var p transcriptParticipant
+		if err := rows.Scan(&p.UserID, &p.Username, &p.Role); err != nil {
+			return nil, err
+		}
+		names[p.UserID] = p.Username
+		t.Participants = append(t.Participants, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	messages, err := h.conversationTranscriptMessages(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range messages {
+		tm := transcriptMessage{
+			ID:          m.ID,
+			SenderID:    m.SenderID,
+			SentAt:      m.CreatedAt.In(location).Format(exportTimeLayout),
+			Deleted:     m.DeletedAt != nil,
+			Redacted:    m.Redacted,
+			Message:     m.Message,
+			Attachments: m.Attachments,
+		}
+		if m.SenderID != nil {
+			tm.SenderName = names[*m.SenderID]
+		}
+		if m.EditedAt != nil {
+			tm.EditedAt = m.EditedAt.In(location).Format(exportTimeLayout)
+		}
+		t.Messages = append(t.Messages, tm)
+	}
+
+	return t, nil
+}
+
+// conversationTranscriptMessages загружает все сообщения беседы от старых к
+// новым вместе с вложениями.
+func (h *Handler) conversationTranscriptMessages(conversationID uuid.UUID) ([]models.ChatMessage, error) {
+	rows, err := h.DB.Query(`
+		SELECT id, sender_id, recipient_id, message, created_at, edited_at, deleted_at, redacted
+		FROM chat_messages
+		WHERE conversation_id = $1
+		ORDER BY created_at, id
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.ChatMessage
+	for rows.Next() {
+		var message models.ChatMessage
+		var encryptedMessage string
+		if err := rows.Scan(
+			&message.ID, &message.SenderID, &message.RecipientID, &encryptedMessage, &message.CreatedAt,
+			&message.EditedAt, &message.DeletedAt, &message.Redacted,
+		); err != nil {
+			return nil, err
+		}
+
+		text, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+		if err != nil {
+			return nil, err
+		}
+		message.ConversationID = &conversationID
+		message.Message = string(text)
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, h.loadAttachments(messages)
+}
+
+// transcriptLines — общий текст выгрузки для txt и pdf.
+func transcriptLines(t *transcript) (header []string, messages [][]string) {
+	title := t.Kind
+	if t.Title != "" {
+		title += " «" + t.Title + "»"
+	}
+	header = []string{
+		"Беседа " + t.ConversationID.String() + " (" + title + ")",
+		"Выгружено: " + t.ExportedAt + " (" + t.Timezone + "), " + t.ExportedBy,
+		"Участники:",
+	}
+	for _, p := range t.Participants {
+		header = append(header, "  "+p.Username+" ("+p.Role+", "+p.UserID.String()+")")
+	}
+
+	for _, m := range t.Messages {
+		sender := m.SenderName
+		if sender == "" {
+			sender = "удалённый пользователь"
+		}
+
+		line := "[" + m.SentAt + "] " + sender + ": "
+		switch {
+		case m.Redacted:
+			line += "[сообщение скрыто модератором]"
+		case m.Deleted:
+			line += "[сообщение удалено]"
+		default:
+			line += m.Message
+			if m.EditedAt != "" {
+				line += " (изменено " + m.EditedAt + ")"
+			}
+		}
+
+		lines := []string{line}
+		for _, a := range m.Attachments {
+			lines = append(lines, fmt.Sprintf("    вложение: %s (%s, %d байт, sha256 %s)", a.FileName, a.MimeType, a.Size, a.Checksum))
+		}
+		messages = append(messages, lines)
+	}
+
+	return header, messages
+}
+
+func renderTranscriptText(t *transcript) []byte {
+	header, messages := transcriptLines(t)
+
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(header, "\n"))
+	buf.WriteString("\n\n")
+	for _, lines := range messages {
+		buf.WriteString(strings.Join(lines, "\n"))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// renderTranscriptPDF собирает PDF. Встроенные шрифты PDF не содержат
+// кириллицы, поэтому нужен TTF-шрифт из CHAT_EXPORT_FONT.
+func renderTranscriptPDF(t *transcript, fontPath string) ([]byte, error) {
+	header, messages := transcriptLines(t)
+
+	pdf := gofpdf.New("P", "mm", "A4", filepath.Dir(fontPath))
+	pdf.AddUTF8Font("transcript", "", filepath.Base(fontPath))
+	pdf.SetFont("transcript", "", 10)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.CellFormat(0, 10, fmt.Sprintf("%d / {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	for _, line := range header {
+		pdf.MultiCell(0, 5, line, "", "L", false)
+	}
+	pdf.Ln(4)
+	for _, lines := range messages {
+		for _, line := range lines {
+			pdf.MultiCell(0, 5, line, "", "L", false)
+		}
+		pdf.Ln(1)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const chatMaxReasonLength = 500
+
+var (
+	errMessageNotFound    = errors.New("chat message not found")
+	errMessageNotOwned    = errors.New("chat message belongs to another user")
+	errMessageEditExpired = errors.New("chat message edit window expired")
+	errMessageDeleted     = errors.New("chat message already deleted")
+)
+
+type editChatMessageRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+type redactChatMessageRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// messageRevision описывает изменение сообщения: автор правит или удаляет
+// своё сообщение в пределах CHAT_EDIT_WINDOW_MINUTES, модератор скрывает
+// любое с указанием причины.
+type messageRevision struct {
+	Action  string
+	ActorID uuid.UUID
+	// Message — новый текст для правки.
+	Message string
+	Reason  string
+}
+
+// reviseMessage сохраняет прежний текст сообщения в истории и применяет
+// revision.
+func (h *Handler) reviseMessage(messageID uuid.UUID, revision messageRevision) error {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var senderID *uuid.UUID
+	var encryptedMessage string
+	var deleted, editable bool
+	err = tx.QueryRow(`
+		SELECT sender_id, message, deleted_at IS NOT NULL, created_at > NOW() - $2 * INTERVAL '1 minute'
+		FROM chat_messages
+		WHERE id = $1
+		FOR UPDATE
+	`, messageID, h.Config.Chat.EditWindowMinutes).Scan(&senderID, &encryptedMessage, &deleted, &editable)
+	if err == sql.ErrNoRows {
+		return errMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if revision.Action != models.RevisionRedact {
+		if senderID == nil || *senderID != revision.ActorID {
+			return errMessageNotOwned
+		}
+		if !editable {
+			return errMessageEditExpired
+		}
+	}
+	if deleted {
+		return errMessageDeleted
+	}
+
+	var reason *string
+	if revision.Reason != "" {
+		reason = &revision.Reason
+	}
+	_, err = tx.Exec(`
+		INSERT INTO chat_message_revisions (message_id, action, message, actor_id, reason)
+		VALUES ($1, $2, $3, $4, $5)
+	`, messageID, revision.Action, encryptedMessage, revision.ActorID, reason)
+	if err != nil {
+		return err
+	}
+
+	// У удалённого сообщения текст заменяется пустым: прежний остаётся
+	// только в истории.
+	encrypted, err := utils.Encrypt([]byte(revision.Message), []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return err
+	}
+
+	switch revision.Action {
+	case models.RevisionEdit:
+		_, err = tx.Exec(
+			"UPDATE chat_messages SET message = $2, edited_at = NOW() WHERE id = $1",
+			messageID, encrypted,
+		)
+	default:
+		_, err = tx.Exec(`
+			UPDATE chat_messages
+			SET message = $2, deleted_at = NOW(), deleted_by = $3, redacted = $4
+			WHERE id = $1
+		`, messageID, encrypted, revision.ActorID, revision.Action == models.RevisionRedact)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	eventType := chatEventDeleted
+	if revision.Action == models.RevisionEdit {
+		eventType = chatEventEdited
+	}
+	h.publishChatEvent(chatEvent{Type: eventType, MessageID: messageID})
+	return nil
+}
+
+// reviseMessageFromRequest применяет revision к сообщению из параметра
+// message_id и отвечает клиенту обновлённым сообщением.
+func (h *Handler) reviseMessageFromRequest(c *gin.Context, revision messageRevision) {
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return
+	}
+
+	err = h.reviseMessage(messageID, revision)
+	switch err {
+	case nil:
+	case errMessageNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return
+	case errMessageNotOwned:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Можно изменять только свои сообщения"})
+		return
+	case errMessageEditExpired:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Время на изменение сообщения истекло"})
+		return
+	case errMessageDeleted:
+		c.JSON(http.StatusConflict, gin.H{"error": "Сообщение уже удалено"})
+		return
+	default:
+		log.Printf("Ошибка изменения сообщения %s: %v", messageID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка изменения сообщения"})
+		return
+	}
+
+	message, _, err := h.loadChatMessage(messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщения"})
+		return
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+// EditChatMessage меняет текст своего сообщения.
+func (h *Handler) EditChatMessage(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req editChatMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Пустое сообщение"})
+		return
+	}
+	if utf8.RuneCountInString(req.Message) > h.Config.Chat.MaxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Сообщение длиннее %d символов", h.Config.Chat.MaxMessageLength)})
+		return
+	}
+
+	h.reviseMessageFromRequest(c, messageRevision{
+		Action:  models.RevisionEdit,
+		ActorID: userID,
+		Message: req.Message,
+	})
+}
+
+// DeleteChatMessage удаляет своё сообщение. Участники беседы получают
+// chat.deleted.
+func (h *Handler) DeleteChatMessage(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	h.reviseMessageFromRequest(c, messageRevision{Action: models.RevisionDelete, ActorID: userID})
+}
+
+// RedactChatMessage скрывает чужое сообщение. Доступно модераторам,
+// причина обязательна и видна администраторам в истории.
+func (h *Handler) RedactChatMessage(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req redactChatMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите причину"})
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > chatMaxReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Причина длиннее %d символов", chatMaxReasonLength)})
+		return
+	}
+
+	h.reviseMessageFromRequest(c, messageRevision{
+		Action:  models.RevisionRedact,
+		ActorID: userID,
+		Reason:  strings.TrimSpace(req.Reason),
+	})
+}
+
+// GetChatMessageHistory отдаёт администратору все прежние версии сообщения
+// от старых к новым.
+func (h *Handler) GetChatMessageHistory(c *gin.Context) {
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return
+	}
+
+	message, _, err := h.loadChatMessage(messageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщения"})
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, action, message, actor_id, COALESCE(reason, ''), created_at
+		FROM chat_message_revisions
+		WHERE message_id = $1
+		ORDER BY created_at, id
+	`, messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории"})
+		return
+	}
+	defer rows.Close()
+
+	revisions := []models.ChatMessageRevision{}
+	for rows.Next() {
+		revision := models.ChatMessageRevision{MessageID: messageID}
+		var encryptedMessage string
+		if err := rows.Scan(
+			&revision.ID, &revision.Action, &encryptedMessage, &revision.ActorID, &revision.Reason, &revision.CreatedAt,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения истории"})
+			return
+		}
+
+		text, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расшифровки сообщения"})
+			return
+		}
+		revision.Message = string(text)
+		revisions = append(revisions, revision)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   message,
+		"revisions": revisions,
+	})
+}
@@ -0,0 +1,638 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	errConversationNotFound  = errors.New("conversation not found")
+	errConversationForbidden = errors.New("conversation forbidden")
+)
+
+// isDepartmentStaff — может ли роль читать и вести обращения граждан в
+// департамент, не будучи их участником.
+func isDepartmentStaff(role string) bool {
+	return models.RoleHasPermission(role, models.PermissionChatHistory)
+}
+
+// canSeeDepartmentConversation — единое правило доступа к обращению
+// гражданина. Гражданин видит своё обращение всегда. Назначенное обращение
+// видят ответственный и модераторы (chat:moderate), неназначенное — участники
+// и все сотрудники. conversationVisibleSQL — то же правило для запросов.
+func canSeeDepartmentConversation(userID uuid.UUID, role string, citizenID, assignedTo *uuid.UUID, member bool) bool {
+	if citizenID != nil && *citizenID == userID {
+		return true
+	}
+	if assignedTo != nil {
+		return *assignedTo == userID || models.RoleHasPermission(role, models.PermissionChatModerate)
+	}
+	return member || isDepartmentStaff(role)
+}
+
+// conversationVisibleSQL возвращает условие на беседу c, равносильное
+// canSeeDepartmentConversation для обращений и членству для остальных бесед.
+// user, staff и moderator — плейсхолдеры с ID пользователя и признаками
+// isDepartmentStaff и chat:moderate.
+func conversationVisibleSQL(user, staff, moderator string) string {
+	member := "EXISTS(SELECT 1 FROM conversation_members vm WHERE vm.conversation_id = c.id AND vm.user_id = " + user + ")"
+	return `(
+		(c.kind <> 'department' AND ` + member + `)
+		OR (c.kind = 'department' AND (
+			c.citizen_id = ` + user + `
+			OR (c.assigned_to IS NULL AND (` + member + ` OR ` + staff + `))
+			OR c.assigned_to = ` + user + `
+			OR (c.assigned_to IS NOT NULL AND ` + moderator + `)
+		))
+	)`
+}
+
+// conversationAccess проверяет, что пользователь может читать беседу, и
+// возвращает её тип. Сотрудник, открывший обращение гражданина, становится
+// его участником, чтобы для него считались непрочитанные сообщения.
+func (h *Handler) conversationAccess(conversationID, userID uuid.UUID, role string) (string, error) {
+	kind, member, err := h.checkConversationAccess(conversationID, userID, role)
+	if err != nil {
+		return "", err
+	}
+
+	if !member {
+		if err := h.addConversationMember(conversationID, userID); err != nil {
+			return "", err
+		}
+	}
+	return kind, nil
+}
+
+// checkConversationAccess проверяет доступ, ничего не меняя, и сообщает,
+// состоит ли пользователь в беседе.
+func (h *Handler) checkConversationAccess(conversationID, userID uuid.UUID, role string) (kind string, member bool, err error) {
+	var citizenID, assignedTo *uuid.UUID
+	err = h.DB.QueryRow(`
+		SELECT c.kind, c.citizen_id, c.assigned_to,
+			EXISTS(SELECT 1 FROM conversation_members m WHERE m.conversation_id = c.id AND m.user_id = $2)
+		FROM conversations c
+		WHERE c.id = $1
+	`, conversationID, userID).Scan(&kind, &citizenID, &assignedTo, &member)
+	if err == sql.ErrNoRows {
+		return "", false, errConversationNotFound
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if kind == models.ConversationDepartment {
+		if !canSeeDepartmentConversation(userID, role, citizenID, assignedTo, member) {
+			return "", false, errConversationForbidden
+		}
+		return kind, member, nil
+	}
+
+	if !member {
+		return "", false, errConversationForbidden
+	}
+	return kind, member, nil
+}
+
+func (h *Handler) addConversationMember(conversationID, userID uuid.UUID) error {
+	_, err := h.DB.Exec(
+		"INSERT INTO conversation_members (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		conversationID, userID,
+	)
+	return err
+}
+
+// departmentConversation возвращает обращение гражданина в департамент,
+// создавая его при первом сообщении.
+func (h *Handler) departmentConversation(citizenID uuid.UUID) (uuid.UUID, error) {
+	var conversationID uuid.UUID
+	err := h.DB.QueryRow(`
+		INSERT INTO conversations (kind, citizen_id, created_by) VALUES ($1, $2, $2)
+		ON CONFLICT (citizen_id) WHERE kind = 'department' DO UPDATE SET kind = EXCLUDED.kind
+		RETURNING id
+	`, models.ConversationDepartment, citizenID).Scan(&conversationID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return conversationID, h.addConversationMember(conversationID, citizenID)
+}
+
+// directConversation возвращает личную беседу двух сотрудников, создавая её
+// при необходимости.
+func (h *Handler) directConversation(userID, otherID uuid.UUID) (uuid.UUID, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка по паре участников не даёт двум параллельным запросам
+	// создать две одинаковые беседы.
+	low, high := userID.String(), otherID.String()
+	if high < low {
+		low, high = high, low
+	}
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", "direct:"+low+":"+high); err != nil {
+		return uuid.Nil, err
+	}
+
+	var conversationID uuid.UUID
+	err = tx.QueryRow(`
+		SELECT c.id FROM conversations c
+		JOIN conversation_members a ON a.conversation_id = c.id AND a.user_id = $1
+		JOIN conversation_members b ON b.conversation_id = c.id AND b.user_id = $2
+		WHERE c.kind = $3
+		LIMIT 1
+	`, userID, otherID, models.ConversationDirect).Scan(&conversationID)
+	if err == nil {
+		return conversationID, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return uuid.Nil, err
+	}
+
+	err = tx.QueryRow(
+		"INSERT INTO conversations (kind, created_by) VALUES ($1, $2) RETURNING id",
+		models.ConversationDirect, userID,
+	).Scan(&conversationID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO conversation_members (conversation_id, user_id) VALUES ($1, $2), ($1, $3)",
+		conversationID, userID, otherID,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return conversationID, tx.Commit()
+}
+
+// resolveConversation определяет беседу для сообщения из сокета. Если беседа
+// не указана, гражданин пишет в своё обращение, а сотрудник — в обращение
+// гражданина recipient_id или в личную беседу с коллегой.
+func (h *Handler) resolveConversation(userID uuid.UUID, role string, conversationID, recipientID *uuid.UUID) (uuid.UUID, string, error) {
+	if conversationID != nil {
+		kind, err := h.conversationAccess(*conversationID, userID, role)
+		return *conversationID, kind, err
+	}
+
+	if role == models.RoleCitizen {
+		id, err := h.departmentConversation(userID)
+		return id, models.ConversationDepartment, err
+	}
+
+	if recipientID == nil || !isDepartmentStaff(role) {
+		return uuid.Nil, "", errConversationNotFound
+	}
+
+	recipientRole, err := h.userRole(*recipientID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, "", errConversationNotFound
+	}
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	if recipientRole == models.RoleCitizen {
+		id, err := h.departmentConversation(*recipientID)
+		if err != nil {
+			return uuid.Nil, "", err
+		}
+		kind, err := h.conversationAccess(id, userID, role)
+		return id, kind, err
+	}
+
+	if *recipientID == userID {
+		return uuid.Nil, "", errConversationForbidden
+	}
+
+	id, err := h.directConversation(userID, *recipientID)
+	return id, models.ConversationDirect, err
+}
+
+func (h *Handler) conversationMemberIDs(conversationID uuid.UUID) (map[uuid.UUID]bool, error) {
+	rows, err := h.DB.Query("SELECT user_id FROM conversation_members WHERE conversation_id = $1", conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members[id] = true
+	}
+	return members, rows.Err()
+}
+
+func (h *Handler) userRole(userID uuid.UUID) (string, error) {
+	var role string
+	err := h.DB.QueryRow("SELECT role FROM users WHERE id = $1 AND disabled_at IS NULL", userID).Scan(&role)
+	return role, err
+}
+
+func (h *Handler) CreateConversation(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req models.CreateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch req.Kind {
+	case models.ConversationDepartment:
+		if role != models.RoleCitizen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Обращение в департамент создаёт только гражданин"})
+			return
+		}
+
+		conversationID, err := h.departmentConversation(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания беседы"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": conversationID})
+
+	case models.ConversationDirect:
+		if !isDepartmentStaff(role) || len(req.MemberIDs) != 1 || req.MemberIDs[0] == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Личная беседа создаётся между двумя сотрудниками"})
+			return
+		}
+
+		if !h.staffMembers(c, req.MemberIDs) {
+			return
+		}
+
+		conversationID, err := h.directConversation(userID, req.MemberIDs[0])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания беседы"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": conversationID})
+
+	case models.ConversationGroup:
+		if !isDepartmentStaff(role) || req.Title == "" || len(req.MemberIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Групповую беседу с названием и участниками создаёт сотрудник"})
+			return
+		}
+
+		if !h.staffMembers(c, req.MemberIDs) {
+			return
+		}
+
+		conversationID, err := h.createGroupConversation(userID, req.Title, req.MemberIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания беседы"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"id": conversationID})
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный тип беседы"})
+	}
+}
+
+// staffMembers проверяет, что все приглашённые — действующие сотрудники.
+func (h *Handler) staffMembers(c *gin.Context, memberIDs []uuid.UUID) bool {
+	for _, memberID := range memberIDs {
+		role, err := h.userRole(memberID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Пользователь не найден"})
+			return false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+			return false
+		}
+		if !isDepartmentStaff(role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "В беседу можно пригласить только сотрудников"})
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) createGroupConversation(creatorID uuid.UUID, title string, memberIDs []uuid.UUID) (uuid.UUID, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var conversationID uuid.UUID
+	err = tx.QueryRow(
+		"INSERT INTO conversations (kind, title, created_by) VALUES ($1, $2, $3) RETURNING id",
+		models.ConversationGroup, title, creatorID,
+	).Scan(&conversationID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	for _, memberID := range append([]uuid.UUID{creatorID}, memberIDs...) {
+		_, err := tx.Exec(
+			"INSERT INTO conversation_members (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			conversationID, memberID,
+		)
+		if err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	return conversationID, tx.Commit()
+}
+
+// GetConversations возвращает беседы пользователя с последним сообщением и
+// числом непрочитанных. Сотрудник с scope=department дополнительно видит
+// обращения граждан, которые ещё не открывал, если они ему доступны.
+func (h *Handler) GetConversations(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	includeDepartment := c.Query("scope") == "department" && isDepartmentStaff(role)
+	limit := queryLimit(c, 50, 200)
+	moderator := models.RoleHasPermission(role, models.PermissionChatModerate)
+
+	rows, err := h.DB.Query(`
+		SELECT c.id, c.kind, COALESCE(c.title, ''), c.citizen_id, c.assigned_to, c.created_at,
+			lm.id, lm.sender_id, lm.recipient_id, lm.message, lm.created_at,
+			lm.edited_at, lm.deleted_at, COALESCE(lm.redacted, FALSE),
+			(
+				SELECT COUNT(*) FROM chat_messages cm
+				WHERE cm.conversation_id = c.id
+					AND cm.created_at > COALESCE(m.last_read_at, '-infinity'::timestamp)
+					AND cm.sender_id IS DISTINCT FROM $1
+			)
+		FROM conversations c
+		LEFT JOIN conversation_members m ON m.conversation_id = c.id AND m.user_id = $1
+		LEFT JOIN LATERAL (
+			SELECT id, sender_id, recipient_id, message, created_at, edited_at, deleted_at, redacted
+			FROM chat_messages
+			WHERE conversation_id = c.id
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		) lm ON TRUE
+		WHERE (m.user_id IS NOT NULL OR (c.kind = 'department' AND $2))
+			AND `+conversationVisibleSQL("$1", "$4", "$5")+`
+		ORDER BY COALESCE(lm.created_at, c.created_at) DESC
+		LIMIT $3
+	`, userID, includeDepartment, limit, isDepartmentStaff(role), moderator)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения бесед"})
+		return
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var conv models.Conversation
+		var messageID *uuid.UUID
+		var message models.ChatMessage
+		var encryptedMessage sql.NullString
+		var messageCreatedAt sql.NullTime
+
+		if err := rows.Scan(
+			&conv.ID, &conv.Kind, &conv.Title, &conv.CitizenID, &conv.AssignedTo, &conv.CreatedAt,
+			&messageID, &message.SenderID, &message.RecipientID, &encryptedMessage, &messageCreatedAt,
+			&message.EditedAt, &message.DeletedAt, &message.Redacted,
+			&conv.UnreadCount,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных беседы"})
+			return
+		}
+
+		if messageID != nil {
+			text, err := utils.Decrypt(encryptedMessage.String, []byte(h.Config.Crypto.Key))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расшифровки сообщения"})
+				return
+			}
+
+			conversationID := conv.ID
+			message.ID = *messageID
+			message.ConversationID = &conversationID
+			message.Message = string(text)
+			message.CreatedAt = messageCreatedAt.Time
+			conv.LastMessage = &message
+		}
+
+		conv.Members = []models.ConversationMember{}
+		conversations = append(conversations, conv)
+		ids = append(ids, conv.ID)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения бесед"})
+		return
+	}
+
+	members, err := h.conversationMembers(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения участников беседы"})
+		return
+	}
+	for i := range conversations {
+		if m, ok := members[conversations[i].ID]; ok {
+			conversations[i].Members = m
+		}
+	}
+
+	c.JSON(http.StatusOK, conversations)
+}
+
+func (h *Handler) conversationMembers(ids []uuid.UUID) (map[uuid.UUID][]models.ConversationMember, error) {
+	result := make(map[uuid.UUID][]models.ConversationMember)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT m.conversation_id, u.id, u.username, u.role
+		FROM conversation_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id = ANY($1)
+		ORDER BY m.joined_at
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID uuid.UUID
+		var member models.ConversationMember
+		if err := rows.Scan(&conversationID, &member.UserID, &member.Username, &member.Role); err != nil {
+			return nil, err
+		}
+		result[conversationID] = append(result[conversationID], member)
+	}
+
+	return result, rows.Err()
+}
+
+// GetConversationMessages отдаёт сообщения беседы от новых к старым. Следующая
+// страница запрашивается с before=next_cursor.
+func (h *Handler) GetConversationMessages(c *gin.Context) {
+	conversationID, ok := h.accessibleConversation(c)
+	if !ok {
+		return
+	}
+
+	limit := queryLimit(c, 50, 200)
+	var before *time.Time
+	var beforeID *uuid.UUID
+	if cursor := c.Query("before"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		before, beforeID = &createdAt, &id
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, sender_id, recipient_id, client_message_id, message, created_at, edited_at, deleted_at, redacted
+		FROM chat_messages
+		WHERE conversation_id = $1 AND ($2::timestamp IS NULL OR (created_at, id) < ($2::timestamp, $3::uuid))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, conversationID, before, beforeID, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
+		return
+	}
+	defer rows.Close()
+
+	messages := []models.ChatMessage{}
+	for rows.Next() {
+		var message models.ChatMessage
+		var encryptedMessage string
+
+		if err := rows.Scan(
+			&message.ID,
+			&message.SenderID,
+			&message.RecipientID,
+			&message.ClientMessageID,
+			&encryptedMessage,
+			&message.CreatedAt,
+			&message.EditedAt,
+			&message.DeletedAt,
+			&message.Redacted,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных сообщения"})
+			return
+		}
+
+		messageBytes, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расшифровки сообщения"})
+			return
+		}
+
+		message.ConversationID = &conversationID
+		message.Message = string(messageBytes)
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
+		return
+	}
+
+	var nextCursor string
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[len(messages)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	userID, _, _ := currentUser(c)
+	var ownIDs []uuid.UUID
+	for _, message := range messages {
+		if message.SenderID != nil && *message.SenderID == userID {
+			ownIDs = append(ownIDs, message.ID)
+		}
+	}
+	if err := h.loadAttachments(messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вложений"})
+		return
+	}
+
+	receipts, err := h.messageReceipts(ownIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения отметок о доставке"})
+		return
+	}
+	for i := range messages {
+		messages[i].Receipts = receipts[messages[i].ID]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    messages,
+		"next_cursor": nextCursor,
+	})
+}
+
+func (h *Handler) MarkConversationAsRead(c *gin.Context) {
+	conversationID, ok := h.accessibleConversation(c)
+	if !ok {
+		return
+	}
+
+	userID, _, _ := currentUser(c)
+	if err := h.markConversationRead(conversationID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления беседы"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Беседа отмечена как прочитанная"})
+}
+
+// accessibleConversation разбирает :conversation_id и проверяет доступ
+// текущего пользователя, отвечая ошибкой при отказе.
+func (h *Handler) accessibleConversation(c *gin.Context) (uuid.UUID, bool) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return uuid.Nil, false
+	}
+
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID беседы"})
+		return uuid.Nil, false
+	}
+
+	_, err = h.conversationAccess(conversationID, userID, role)
+	switch err {
+	case nil:
+		return conversationID, true
+	case errConversationNotFound, errConversationForbidden:
+		// Чужие беседы неотличимы от несуществующих.
+		c.JSON(http.StatusNotFound, gin.H{"error": "Беседа не найдена"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения беседы"})
+	}
+	return uuid.Nil, false
+}
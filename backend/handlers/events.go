@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// sseKeepAlive — как часто в поток пишется комментарий, чтобы прокси не
+// закрывали простаивающее соединение.
+const sseKeepAlive = 25 * time.Second
+
+// EventStream — запасной канал для клиентов, у которых не открывается
+// WebSocket. Отдаёт те же кадры, что и /ws/chat, в формате Server-Sent
+// Events: имя события — тип кадра, данные — конверт целиком. У chat.message
+// id события — курсор сообщения, поэтому после обрыва браузер сам присылает
+// его в Last-Event-ID, и пропущенные сообщения отправляются заново.
+func (h *Handler) EventStream(c *gin.Context) {
+	identity, err := h.authenticateEventStream(c.Request)
+	if err == errWSUnauthorized {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки авторизации"})
+		return
+	}
+
+	client := newWebSocketClient(nil, identity)
+	if err := h.Hub.register(client); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Сервер останавливается"})
+		return
+	}
+	defer h.Hub.unregister(client)
+	defer h.disconnectPresence(client)
+	defer client.Close(0, "")
+
+	h.connectPresence(client)
+
+	go h.watchWebSocketSession(client, identity)
+	go h.presenceHeartbeat(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	cursor := c.GetHeader("Last-Event-ID")
+	if cursor == "" {
+		cursor = c.Query("since")
+	}
+	if cursor != "" {
+		// replayMessages ждёт места в очереди, а разбирает её этот же
+		// обработчик, поэтому пропущенные сообщения собираются отдельно.
+		go h.replayMessages(client, "", cursor)
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-client.send:
+			if err := writeSSEFrame(c, data); err != nil {
+				log.Printf("Ошибка отправки события: %v", err)
+				return
+			}
+		case <-ticker.C:
+			if _, err := c.Writer.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-client.closing:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEFrame(c *gin.Context, data []byte) error {
+	var env wsEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return err
+	}
+
+	event := sse.Event{Event: env.Type, Data: string(data)}
+	if env.Type == wsTypeChatMessage {
+		var payload chatMessagePayload
+		if err := json.Unmarshal(env.Payload, &payload); err == nil {
+			event.Id = payload.Cursor
+		}
+	}
+
+	if err := sse.Encode(c.Writer, event); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// SendChatMessage отправляет сообщение чата без WebSocket. Тело совпадает с
+// payload кадра chat.send, ответ — с payload chat.ack.
+func (h *Handler) SendChatMessage(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req chatSendPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(h.Config.Chat); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.sendChatMessage(userID, role, req, uuid.Nil)
+	if err == errConversationNotFound || err == errConversationForbidden {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Беседа не найдена"})
+		return
+	}
+	if err == errAttachmentNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Вложение не найдено"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения сообщения"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, ackPayload(message))
+}
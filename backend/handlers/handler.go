@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"backend/bus"
 	"backend/config"
+	"backend/database"
+	"backend/mail"
+	"backend/utils"
 	"database/sql"
-	"net/http"
+	"errors"
+	"log"
 
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -12,22 +19,93 @@ type Handler struct {
 	DB       *sql.DB
 	Config   *config.Config
 	Upgrader websocket.Upgrader
-	Clients  map[string]*WebSocketClient
+	Hub      *Hub
+	Guards   LoginGuards
+	Mailer   mail.Mailer
+	Keys     *utils.KeySet
+	Bus      bus.Bus
+
+	// stop закрывается в Shutdown и останавливает фоновые задачи.
+	stop chan struct{}
 }
 
-func NewHandler(db *sql.DB) *Handler {
-	cfg, _ := config.LoadConfig()
+func NewHandler(db *sql.DB, cfg *config.Config) (*Handler, error) {
+	keys, err := loadKeySet(cfg.Env, cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Handler{
+	h := &Handler{
 		DB:     db,
 		Config: cfg,
 		Upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			CheckOrigin:     checkOrigin(cfg.Server.AllowedOrigins),
+			// Порядок важен: gorilla выбирает первый протокол из этого списка,
+			// который предложил клиент, а не первый в списке клиента. Поэтому
+			// msgpack выигрывает у json, а bearer отдаётся, только если клиент
+			// не предложил ни одного формата.
+			Subprotocols: []string{wsProtocolMsgpack, wsProtocolJSON, wsBearerProtocol},
 		},
-		Clients: make(map[string]*WebSocketClient),
+		Hub:    NewHub(),
+		Guards: newLoginGuards(db, cfg.Security),
+		Mailer: newMailer(cfg.Mail),
+		Keys:   keys,
+		Bus:    newBus(db, cfg),
+		stop:   make(chan struct{}),
+	}
+
+	if err := h.Bus.Subscribe(chatChannel, h.deliverChatEvent); err != nil {
+		h.Bus.Close()
+		return nil, err
+	}
+
+	if cfg.Chat.OrphanAttachmentHours > 0 {
+		go h.cleanupOrphanAttachments()
 	}
+
+	return h, nil
+}
+
+func newBus(db *sql.DB, cfg *config.Config) bus.Bus {
+	if cfg.Chat.Bus == "postgres" {
+		return bus.NewPostgresBus(db, database.ConnString(cfg.Database))
+	}
+	return bus.NewMemoryBus()
+}
+
+// loadKeySet загружает ключи подписи из JWT_KEYS_DIR. Временный ключ
+// допустим только при разработке: после перезапуска все выданные токены
+// становятся недействительными, а у нескольких экземпляров ключи разные.
+func loadKeySet(env string, cfg config.JWTConfig) (*utils.KeySet, error) {
+	if cfg.KeysDir == "" {
+		if env != "development" {
+			return nil, errors.New("JWT_KEYS_DIR не задан: временный ключ подписи допустим только при APP_ENV=development")
+		}
+		log.Println("JWT_KEYS_DIR не задан, используется временный ключ подписи")
+		return utils.NewEphemeralKeySet(cfg.Issuer)
+	}
+	return utils.LoadKeySet(cfg.KeysDir, cfg.SigningKeyID, cfg.Issuer)
+}
+
+// currentUser возвращает пользователя и роль, установленные AuthMiddleware.
+func currentUser(c *gin.Context) (uuid.UUID, string, bool) {
+	value, exists := c.Get("user_id")
+	if !exists {
+		return uuid.Nil, "", false
+	}
+
+	userID, ok := value.(uuid.UUID)
+	if !ok {
+		return uuid.Nil, "", false
+	}
+
+	return userID, c.GetString("role"), true
+}
+
+func currentSessionID(c *gin.Context) uuid.UUID {
+	value, _ := c.Get("session_id")
+	sessionID, _ := value.(uuid.UUID)
+	return sessionID
 }
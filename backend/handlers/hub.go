@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsSendBuffer — сколько исходящих сообщений может ждать отправки.
+	// Клиент, который не успевает их забирать, отключается.
+	wsSendBuffer = 64
+)
+
+var errHubClosed = errors.New("websocket: hub closed")
+
+type WebSocketClient struct {
+	ID uuid.UUID
+	// Conn — nil у клиентов /api/events: их очередь разбирает EventStream.
+	Conn      *websocket.Conn
+	UserID    *uuid.UUID
+	Role      string
+	SessionID uuid.UUID
+
+	// binary — клиент выбрал подпротокол msgpack. В очереди кадры хранятся
+	// в JSON и переводятся в MessagePack перед записью.
+	binary bool
+
+	send        chan []byte
+	closing     chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	mu sync.Mutex
+	// incidents — подписка на ленту инцидентов, см. incident.subscribe.
+	incidents incidentFilter
+
+	// typing — когда клиент последний раз начал печатать в беседе. Доступно
+	// только читающей горутине клиента.
+	typing map[uuid.UUID]time.Time
+}
+
+func newWebSocketClient(conn *websocket.Conn, identity *wsIdentity) *WebSocketClient {
+	return &WebSocketClient{
+		ID:        uuid.New(),
+		Conn:      conn,
+		UserID:    &identity.UserID,
+		Role:      identity.Role,
+		SessionID: identity.SessionID,
+		send:      make(chan []byte, wsSendBuffer),
+		closing:   make(chan struct{}),
+		typing:    make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Send ставит сообщение в очередь, не блокируясь. Если буфер переполнен,
+// клиент отключается и сообщение отбрасывается.
+func (c *WebSocketClient) Send(message []byte) bool {
+	select {
+	case <-c.closing:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+		log.Printf("Клиент %s не успевает получать сообщения, соединение закрыто", c.UserID)
+		c.Close(websocket.CloseTryAgainLater, "send buffer overflow")
+		return false
+	}
+}
+
+func (c *WebSocketClient) incidentFilter() incidentFilter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.incidents
+}
+
+func (c *WebSocketClient) setIncidentFilter(filter incidentFilter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.incidents = filter
+}
+
+// Close просит writePump отправить кадр закрытия и закрыть соединение.
+// Повторные вызовы ничего не делают.
+func (c *WebSocketClient) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.closing)
+	})
+}
+
+// writePump — единственная горутина, которая пишет в соединение: сообщения
+// из очереди и ping раз в wsPingPeriod.
+func (c *WebSocketClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-c.send:
+			messageType := websocket.TextMessage
+			if c.binary {
+				data, err := jsonToMsgpack(message)
+				if err != nil {
+					log.Printf("Ошибка кодирования MessagePack: %v", err)
+					continue
+				}
+				messageType, message = websocket.BinaryMessage, data
+			}
+
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.Conn.WriteMessage(messageType, message); err != nil {
+				log.Printf("Ошибка отправки: %v", err)
+				c.Close(websocket.CloseAbnormalClosure, "")
+				c.Conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.Close(websocket.CloseAbnormalClosure, "")
+				c.Conn.Close()
+				return
+			}
+		case <-c.closing:
+			closeWebSocket(c.Conn, c.closeCode, c.closeReason)
+			return
+		}
+	}
+}
+
+// Hub хранит подключённых клиентов и рассылает им сообщения. Рассылка не
+// блокируется на медленных клиентах: у каждого своя очередь и writePump.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*WebSocketClient]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*WebSocketClient]struct{})}
+}
+
+func (hub *Hub) register(client *WebSocketClient) error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.closed {
+		return errHubClosed
+	}
+	hub.clients[client] = struct{}{}
+	hub.wg.Add(1)
+	return nil
+}
+
+func (hub *Hub) unregister(client *WebSocketClient) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if _, ok := hub.clients[client]; ok {
+		delete(hub.clients, client)
+		hub.wg.Done()
+	}
+}
+
+// Broadcast отправляет сообщение всем клиентам, для которых match возвращает true.
+func (hub *Hub) Broadcast(message []byte, match func(*WebSocketClient) bool) {
+	hub.mu.RLock()
+	recipients := make([]*WebSocketClient, 0, len(hub.clients))
+	for client := range hub.clients {
+		if match(client) {
+			recipients = append(recipients, client)
+		}
+	}
+	hub.mu.RUnlock()
+
+	for _, client := range recipients {
+		client.Send(message)
+	}
+}
+
+// Shutdown перестаёт принимать подключения, закрывает существующие с кодом
+// 1001 и ждёт, пока их обработчики завершатся, или истечёт ctx.
+func (hub *Hub) Shutdown(ctx context.Context) error {
+	hub.mu.Lock()
+	hub.closed = true
+	for client := range hub.clients {
+		client.Close(websocket.CloseGoingAway, "server shutdown")
+	}
+	hub.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		hub.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"backend/models"
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// AssignIncident назначает инцидент сотруднику и (или) участку. Каждое
+// изменение назначения записывается в audit_log с прежним и новым значением.
+func (h *Handler) AssignIncident(c *gin.Context) {
+	incidentID, err := uuid.Parse(c.Param("incident_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID инцидента"})
+		return
+	}
+
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req models.AssignIncidentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные данные назначения"})
+		return
+	}
+
+	if req.StationID != nil {
+		if _, ok := policeStationByID(*req.StationID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Участок не найден"})
+			return
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения инцидента"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Сотрудник проверяется в транзакции и блокируется до её конца, чтобы
+	// его не успели отключить между проверкой и назначением.
+	if req.OfficerID != nil {
+		var role string
+		var disabled bool
+		err := tx.QueryRow(
+			"SELECT role, disabled_at IS NOT NULL FROM users WHERE id = $1 FOR SHARE",
+			*req.OfficerID,
+		).Scan(&role, &disabled)
+		if err == sql.ErrNoRows || (err == nil && !models.RoleHasPermission(role, models.PermissionIncidentsManage)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Инцидент можно назначить только сотруднику"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+			return
+		}
+		if disabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Учётная запись сотрудника отключена"})
+			return
+		}
+	}
+
+	var previousOfficerID *uuid.UUID
+	var previousStationID *int
+	err = tx.QueryRow(
+		"SELECT assigned_officer_id, station_id FROM incidents WHERE id = $1 FOR UPDATE",
+		incidentID,
+	).Scan(&previousOfficerID, &previousStationID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Инцидент не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return
+	}
+
+	// Снятие назначения (оба поля пустые) сбрасывает и время назначения.
+	if _, err := tx.Exec(`
+		UPDATE incidents SET assigned_officer_id = $2, station_id = $3,
+			assigned_at = CASE WHEN $2::uuid IS NULL AND $3::integer IS NULL THEN NULL ELSE NOW() END
+		WHERE id = $1
+	`, incidentID, req.OfficerID, req.StationID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения инцидента"})
+		return
+	}
+
+	err = writeAudit(tx, userID, "incident.assign", "incident", incidentID, gin.H{
+		"from_officer_id": previousOfficerID,
+		"from_station_id": previousStationID,
+		"to_officer_id":   req.OfficerID,
+		"to_station_id":   req.StationID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка записи в журнал аудита"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения инцидента"})
+		return
+	}
+
+	h.publishIncidentEvent(incidentUpdated, incidentID, uuid.Nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":                  incidentID,
+		"assigned_officer_id": req.OfficerID,
+		"station_id":          req.StationID,
+	})
+}
+
+// GetIncidentWorkload показывает, сколько незакрытых инцидентов у каждого
+// сотрудника и участка, чтобы старший смены мог распределять нагрузку.
+func (h *Handler) GetIncidentWorkload(c *gin.Context) {
+	openStatuses := pq.Array(models.OpenIncidentStatuses())
+
+	rows, err := h.DB.Query(`
+		SELECT u.id, u.username, COUNT(i.id)
+		FROM users u
+		LEFT JOIN incidents i ON i.assigned_officer_id = u.id AND i.status = ANY($2)
+		WHERE u.role = ANY($1) AND u.disabled_at IS NULL
+		GROUP BY u.id
+		ORDER BY COUNT(i.id) DESC, u.username
+	`, pq.Array(models.RolesWithPermission(models.PermissionIncidentsManage)), openStatuses)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нагрузки"})
+		return
+	}
+	defer rows.Close()
+
+	officers := []models.OfficerWorkload{}
+	for rows.Next() {
+		var w models.OfficerWorkload
+		if err := rows.Scan(&w.OfficerID, &w.Username, &w.Open); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения нагрузки"})
+			return
+		}
+		officers = append(officers, w)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нагрузки"})
+		return
+	}
+
+	stationRows, err := h.DB.Query(`
+		SELECT station_id, COUNT(*)
+		FROM incidents
+		WHERE station_id IS NOT NULL AND status = ANY($1)
+		GROUP BY station_id
+	`, openStatuses)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нагрузки"})
+		return
+	}
+	defer stationRows.Close()
+
+	open := make(map[int]int)
+	for stationRows.Next() {
+		var stationID, count int
+		if err := stationRows.Scan(&stationID, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения нагрузки"})
+			return
+		}
+		open[stationID] = count
+	}
+	if err := stationRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нагрузки"})
+		return
+	}
+
+	stations := make([]models.StationWorkload, 0, len(policeStations))
+	for _, station := range policeStations {
+		stations = append(stations, models.StationWorkload{
+			StationID: station.ID,
+			Name:      station.Name,
+			Open:      open[station.ID],
+		})
+	}
+
+	var unassigned int
+	err = h.DB.QueryRow(`
+		SELECT COUNT(*) FROM incidents
+		WHERE assigned_officer_id IS NULL AND station_id IS NULL AND status = ANY($1)
+	`, openStatuses).Scan(&unassigned)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нагрузки"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"officers":   officers,
+		"stations":   stations,
+		"unassigned": unassigned,
+	})
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+const (
+	incidentCreated = "created"
+	incidentMessage = "message"
+	incidentUpdated = "updated"
+)
+
+const wsMaxSubscriptionItems = 50
+
+// incidentFilter — подписка клиента на ленту инцидентов. Пустой список
+// участков или тегов означает «все».
+type incidentFilter struct {
+	StationIDs []int
+	Tags       []string
+}
+
+func (f incidentFilter) match(stationID *int, tags []string) bool {
+	if len(f.StationIDs) > 0 {
+		if stationID == nil {
+			return false
+		}
+		found := false
+		for _, id := range f.StationIDs {
+			if id == *stationID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		for _, want := range f.Tags {
+			for _, tag := range tags {
+				if tag == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+type incidentSubscribePayload struct {
+	StationIDs []int    `json:"station_ids"`
+	Tags       []string `json:"tags"`
+}
+
+func (p *incidentSubscribePayload) validate(cfg config.ChatConfig) error {
+	if len(p.StationIDs) > wsMaxSubscriptionItems || len(p.Tags) > wsMaxSubscriptionItems {
+		return fmt.Errorf("Не больше %d участков и тегов", wsMaxSubscriptionItems)
+	}
+	for _, id := range p.StationIDs {
+		if _, ok := policeStationByID(id); !ok {
+			return fmt.Errorf("Участок %d не найден", id)
+		}
+	}
+	for _, tag := range p.Tags {
+		if tag == "" {
+			return errors.New("Пустой тег")
+		}
+	}
+	return nil
+}
+
+type incidentEventPayload struct {
+	Incident *models.Incident        `json:"incident"`
+	Message  *models.IncidentMessage `json:"message,omitempty"`
+	// StationID — участок, которому назначен инцидент, а если он не назначен —
+	// ближайший к месту происшествия, если известны координаты.
+	StationID *int `json:"station_id"`
+}
+
+func policeStationByID(id int) (PoliceStation, bool) {
+	for _, station := range policeStations {
+		if station.ID == id {
+			return station, true
+		}
+	}
+	return PoliceStation{}, false
+}
+
+// publishIncidentEvent сообщает сотрудникам о новом инциденте (created),
+// сообщении по нему (message, messageID обязателен) или изменении (updated).
+func (h *Handler) publishIncidentEvent(kind string, incidentID uuid.UUID, messageID uuid.UUID) {
+	h.publishChatEvent(chatEvent{
+		Type:       chatEventIncident,
+		Status:     kind,
+		IncidentID: incidentID,
+		MessageID:  messageID,
+	})
+}
+
+// deliverIncident рассылает событие инцидента сотрудникам, которые могут
+// читать инциденты и чья подписка ему соответствует.
+func (h *Handler) deliverIncident(event chatEvent) {
+	incident, err := h.scanIncident(h.DB.QueryRow("SELECT "+incidentColumns+" FROM incidents WHERE id = $1", event.IncidentID))
+	if err != nil {
+		log.Printf("Ошибка загрузки инцидента %s: %v", event.IncidentID, err)
+		return
+	}
+
+	payload := incidentEventPayload{Incident: &incident, StationID: incident.StationID}
+	if payload.StationID == nil && incident.Latitude != nil && incident.Longitude != nil {
+		station, _ := nearestPoliceStation(*incident.Latitude, *incident.Longitude)
+		payload.StationID = &station.ID
+	}
+
+	frameType := wsTypeIncidentUpdated
+	switch event.Status {
+	case incidentCreated:
+		frameType = wsTypeIncidentCreated
+	case incidentMessage:
+		frameType = wsTypeIncidentMessage
+
+		var message models.IncidentMessage
+		var encryptedMessage string
+		err := h.DB.QueryRow(
+			"SELECT id, incident_id, sender_id, message, created_at, from_citizen, internal FROM incident_messages WHERE id = $1",
+			event.MessageID,
+		).Scan(
+			&message.ID, &message.IncidentID, &message.SenderID, &encryptedMessage, &message.CreatedAt,
+			&message.FromCitizen, &message.Internal,
+		)
+		if err != nil {
+			log.Printf("Ошибка загрузки сообщения инцидента %s: %v", event.MessageID, err)
+			return
+		}
+
+		text, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
+		if err != nil {
+			log.Printf("Ошибка расшифровки сообщения инцидента %s: %v", event.MessageID, err)
+			return
+		}
+		message.Message = string(text)
+		payload.Message = &message
+	}
+
+	data, err := encodeFrame(frameType, payload)
+	if err != nil {
+		log.Printf("Ошибка сериализации события инцидента: %v", err)
+		return
+	}
+
+	h.Hub.Broadcast(data, func(client *WebSocketClient) bool {
+		if !models.RoleHasPermission(client.Role, models.PermissionIncidentsRead) {
+			return false
+		}
+		return client.incidentFilter().match(payload.StationID, incident.Tags)
+	})
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	incidentListDefaultLimit = 50
+	incidentListMaxLimit     = 200
+
+	incidentSortNewest = "newest"
+	incidentSortOldest = "oldest"
+
+	incidentColumns = `id, sender_name, subject, excerpt, created_at, unread, status, tags, media_urls,
+		latitude, longitude, assigned_officer_id, station_id`
+)
+
+// scanIncident читает строку, выбранную по incidentColumns, и расшифровывает
+// описание.
+func (h *Handler) scanIncident(row interface{ Scan(...interface{}) error }) (models.Incident, error) {
+	var incident models.Incident
+	var encryptedExcerpt string
+	err := row.Scan(
+		&incident.ID, &incident.SenderName, &incident.Subject, &encryptedExcerpt, &incident.CreatedAt,
+		&incident.Unread, &incident.Status, pq.Array(&incident.Tags), pq.Array(&incident.MediaURLs),
+		&incident.Latitude, &incident.Longitude, &incident.AssignedOfficerID, &incident.StationID,
+	)
+	if err != nil {
+		return incident, err
+	}
+
+	excerpt, err := utils.Decrypt(encryptedExcerpt, []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return incident, err
+	}
+	incident.Excerpt = string(excerpt)
+	return incident, nil
+}
+
+// incidentFilters переводит параметры запроса списка инцидентов в условия
+// WHERE. Ошибка содержит текст для ответа клиенту.
+func (h *Handler) incidentFilters(c *gin.Context) ([]string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if value := c.Query("status"); value != "" {
+		statuses := splitList(value)
+		for _, status := range statuses {
+			if !models.IsValidIncidentStatus(status) {
+				return nil, nil, fmt.Errorf("Неизвестный статус %s", status)
+			}
+		}
+		conditions = append(conditions, "status = ANY("+arg(pq.Array(statuses))+")")
+	}
+
+	if value := c.Query("tags"); value != "" {
+		conditions = append(conditions, "tags && "+arg(pq.Array(splitList(value)))+"::varchar[]")
+	}
+
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseDateParam(value)
+		if err != nil {
+			return nil, nil, errors.New("Неверный формат from")
+		}
+		conditions = append(conditions, "created_at >= "+arg(from))
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseDateParam(value)
+		if err != nil {
+			return nil, nil, errors.New("Неверный формат to")
+		}
+		// Дата без времени включает весь день.
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		conditions = append(conditions, "created_at < "+arg(to))
+	}
+
+	switch c.Query("unread") {
+	case "true":
+		conditions = append(conditions, "unread")
+	case "false":
+		conditions = append(conditions, "NOT unread")
+	case "":
+	default:
+		return nil, nil, errors.New("unread должен быть true или false")
+	}
+
+	assignee := c.Query("assignee")
+	if c.Query("mine") == "true" {
+		assignee = "me"
+	}
+	switch assignee {
+	case "":
+	case "none":
+		conditions = append(conditions, "assigned_officer_id IS NULL")
+	case "me":
+		userID, _, _ := currentUser(c)
+		conditions = append(conditions, "assigned_officer_id = "+arg(userID))
+	default:
+		officerID, err := uuid.Parse(assignee)
+		if err != nil {
+			return nil, nil, errors.New("assignee должен быть ID сотрудника, me или none")
+		}
+		conditions = append(conditions, "assigned_officer_id = "+arg(officerID))
+	}
+
+	if value := c.Query("station_id"); value != "" {
+		stationID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, errors.New("Неверный ID участка")
+		}
+		conditions = append(conditions, "station_id = "+arg(stationID))
+	}
+
+	if value := c.Query("bbox"); value != "" {
+		var box [4]float64
+		parts := strings.Split(value, ",")
+		if len(parts) != len(box) {
+			return nil, nil, errors.New("bbox задаётся как min_lat,min_lon,max_lat,max_lon")
+		}
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, nil, errors.New("bbox задаётся как min_lat,min_lon,max_lat,max_lon")
+			}
+			box[i] = v
+		}
+		conditions = append(conditions,
+			"latitude BETWEEN "+arg(box[0])+" AND "+arg(box[2]),
+			"longitude BETWEEN "+arg(box[1])+" AND "+arg(box[3]),
+		)
+	}
+
+	if value := c.Query("radius_km"); value != "" {
+		radius, err := strconv.ParseFloat(value, 64)
+		if err != nil || radius <= 0 {
+			return nil, nil, errors.New("Неверный радиус")
+		}
+		lat, latErr := strconv.ParseFloat(c.Query("latitude"), 64)
+		lon, lonErr := strconv.ParseFloat(c.Query("longitude"), 64)
+		if latErr != nil || lonErr != nil {
+			return nil, nil, errors.New("Для radius_km нужно указать latitude и longitude")
+		}
+		// Та же формула гаверсинуса, что и в calculateDistance.
+		latArg, lonArg := arg(lat), arg(lon)
+		conditions = append(conditions, fmt.Sprintf(`6371 * 2 * ASIN(SQRT(
+			POWER(SIN(RADIANS(latitude - %[1]s::float8) / 2), 2) +
+			COS(RADIANS(%[1]s::float8)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - %[2]s::float8) / 2), 2)
+		)) <= %[3]s`, latArg, lonArg, arg(radius)))
+	}
+
+	// Поиск по словам идёт по слепым индексам: каждое слово запроса должно
+	// встретиться в теме, описании или в одном из сообщений инцидента.
+	if value := strings.TrimSpace(c.Query("q")); value != "" {
+		tokens := utils.BlindQuery(value, []byte(h.Config.Crypto.SearchKey))
+		if len(tokens) == 0 {
+			return nil, nil, errors.New("В запросе нет слов для поиска")
+		}
+		for _, token := range tokens {
+			tokenArg := arg(pq.Array([]string{token}))
+			conditions = append(conditions, fmt.Sprintf(`(search_index @> %[1]s::text[] OR EXISTS (
+				SELECT 1 FROM incident_messages m WHERE m.incident_id = incidents.id AND m.search_index @> %[1]s::text[]
+			))`, tokenArg))
+		}
+	}
+
+	return conditions, args, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDateParam принимает RFC 3339 или дату YYYY-MM-DD; dateOnly сообщает,
+// что время не указано.
+func parseDateParam(value string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	return t, true, err
+}
+
+// GetIncidents возвращает страницу инцидентов без сообщений. Фильтры
+// описаны в incidentFilters, порядок задаёт ?sort=newest|oldest, следующая
+// страница запрашивается по next_cursor. total и counts считаются по всем
+// инцидентам, подходящим под фильтры.
+func (h *Handler) GetIncidents(c *gin.Context) {
+	conditions, args, err := h.incidentFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT status, COUNT(*), COUNT(*) FILTER (WHERE unread)
+		FROM incidents `+where+`
+		GROUP BY status
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидентов"})
+		return
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	var total, unread int
+	for rows.Next() {
+		var status string
+		var count, unreadCount int
+		if err := rows.Scan(&status, &count, &unreadCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных инцидента"})
+			return
+		}
+		counts[status] = count
+		total += count
+		unread += unreadCount
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных инцидента"})
+		return
+	}
+
+	order, compare := "DESC", "<"
+	switch c.DefaultQuery("sort", incidentSortNewest) {
+	case incidentSortNewest:
+	case incidentSortOldest:
+		order, compare = "ASC", ">"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort должен быть newest или oldest"})
+		return
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		args = append(args, createdAt, id)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) %s ($%d, $%d)", compare, len(args)-1, len(args)))
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := queryLimit(c, incidentListDefaultLimit, incidentListMaxLimit)
+	args = append(args, limit+1)
+	pageRows, err := h.DB.Query(fmt.Sprintf(`
+		SELECT %s
+		FROM incidents %s
+		ORDER BY created_at %s, id %s
+		LIMIT $%d
+	`, incidentColumns, where, order, order, len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидентов"})
+		return
+	}
+	defer pageRows.Close()
+
+	incidents := []models.Incident{}
+	for pageRows.Next() {
+		incident, err := h.scanIncident(pageRows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных инцидента"})
+			return
+		}
+		incidents = append(incidents, incident)
+	}
+	if err := pageRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных инцидента"})
+		return
+	}
+
+	var nextCursor string
+	if len(incidents) > limit {
+		incidents = incidents[:limit]
+		last := incidents[limit-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"incidents":   incidents,
+		"next_cursor": nextCursor,
+		"total":       total,
+		"unread":      unread,
+		"counts":      counts,
+	})
+}
+
+// GetIncident возвращает инцидент вместе с перепиской.
+func (h *Handler) GetIncident(c *gin.Context) {
+	incidentID, err := uuid.Parse(c.Param("incident_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID инцидента"})
+		return
+	}
+
+	incident, err := h.scanIncident(h.DB.QueryRow("SELECT "+incidentColumns+" FROM incidents WHERE id = $1", incidentID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Инцидент не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return
+	}
+
+	incident.Messages, err = h.getIncidentMessages(incident.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений инцидента"})
+		return
+	}
+
+	c.JSON(http.StatusOK, incident)
+}
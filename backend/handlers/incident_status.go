@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"backend/models"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const incidentMaxReasonLength = 1000
+
+// UpdateIncidentStatus переводит инцидент в новый статус, если такой переход
+// разрешён, и записывает его в incident_status_history.
+func (h *Handler) UpdateIncidentStatus(c *gin.Context) {
+	incidentID, err := uuid.Parse(c.Param("incident_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID инцидента"})
+		return
+	}
+
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req models.UpdateIncidentStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не указан статус"})
+		return
+	}
+	if !models.IsValidIncidentStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный статус"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > incidentMaxReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Причина длиннее %d символов", incidentMaxReasonLength)})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса инцидента"})
+		return
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow("SELECT status FROM incidents WHERE id = $1 FOR UPDATE", incidentID).Scan(&current)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Инцидент не найден"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return
+	}
+
+	if !models.IncidentTransitionAllowed(current, req.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Нельзя перевести инцидент из %s в %s", current, req.Status)})
+		return
+	}
+	if req.Reason == "" && models.IncidentTransitionNeedsReason(current, req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Для этого перехода нужно указать причину"})
+		return
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE incidents SET status = $2, status_changed_at = NOW() WHERE id = $1",
+		incidentID, req.Status,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса инцидента"})
+		return
+	}
+
+	change, err := recordIncidentStatus(tx, incidentID, &current, req.Status, req.Reason, &userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка записи истории статусов"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса инцидента"})
+		return
+	}
+
+	h.publishIncidentEvent(incidentUpdated, incidentID, uuid.Nil)
+
+	c.JSON(http.StatusOK, change)
+}
+
+// recordIncidentStatus добавляет запись в историю статусов инцидента.
+func recordIncidentStatus(tx *sql.Tx, incidentID uuid.UUID, from *string, to, reason string, changedBy *uuid.UUID) (*models.IncidentStatusChange, error) {
+	change := models.IncidentStatusChange{
+		IncidentID: incidentID,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     reason,
+		ChangedBy:  changedBy,
+	}
+
+	var reasonValue *string
+	if reason != "" {
+		reasonValue = &reason
+	}
+	err := tx.QueryRow(`
+		INSERT INTO incident_status_history (incident_id, from_status, to_status, reason, changed_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, incidentID, from, to, reasonValue, changedBy).Scan(&change.ID, &change.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+func (h *Handler) GetIncidentStatusHistory(c *gin.Context) {
+	incidentID, err := uuid.Parse(c.Param("incident_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID инцидента"})
+		return
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM incidents WHERE id = $1)", incidentID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Инцидент не найден"})
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, from_status, to_status, COALESCE(reason, ''), changed_by, created_at
+		FROM incident_status_history
+		WHERE incident_id = $1
+		ORDER BY created_at, id
+	`, incidentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории статусов"})
+		return
+	}
+	defer rows.Close()
+
+	history := []models.IncidentStatusChange{}
+	for rows.Next() {
+		change := models.IncidentStatusChange{IncidentID: incidentID}
+		if err := rows.Scan(
+			&change.ID, &change.FromStatus, &change.ToStatus, &change.Reason, &change.ChangedBy, &change.CreatedAt,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения истории статусов"})
+			return
+		}
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения истории статусов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	trackingCodeGroups    = 4
+	trackingCodeGroupSize = 4
+)
+
+// generateTrackingCode возвращает код вида xxxx-xxxx-xxxx-xxxx из символов,
+// которые трудно спутать при вводе вручную.
+func generateTrackingCode() (string, error) {
+	raw, err := utils.RandomString(trackingCodeGroups * trackingCodeGroupSize)
+	if err != nil {
+		return "", err
+	}
+
+	groups := make([]string, 0, trackingCodeGroups)
+	for i := 0; i < len(raw); i += trackingCodeGroupSize {
+		groups = append(groups, raw[i:i+trackingCodeGroupSize])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizeTrackingCode убирает дефисы, пробелы и регистр, чтобы код
+// совпадал в каком бы виде его ни ввели.
+func normalizeTrackingCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// citizenIncidentID находит инцидент заявителя: по коду отслеживания из
+// :code или по :incident_id среди инцидентов текущего пользователя. Если
+// инцидента нет, отвечает клиенту сам.
+func (h *Handler) citizenIncidentID(c *gin.Context) (uuid.UUID, bool) {
+	var incidentID uuid.UUID
+	var err error
+
+	if code := c.Param("code"); code != "" {
+		err = h.DB.QueryRow(
+			"SELECT id FROM incidents WHERE tracking_code_hash = $1",
+			utils.HashToken(normalizeTrackingCode(code)),
+		).Scan(&incidentID)
+	} else {
+		userID, _, ok := currentUser(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return uuid.Nil, false
+		}
+		id, parseErr := uuid.Parse(c.Param("incident_id"))
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID инцидента"})
+			return uuid.Nil, false
+		}
+		err = h.DB.QueryRow(
+			"SELECT id FROM incidents WHERE id = $1 AND citizen_id = $2",
+			id, userID,
+		).Scan(&incidentID)
+	}
+
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Инцидент не найден"})
+		return uuid.Nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return uuid.Nil, false
+	}
+	return incidentID, true
+}
+
+// loadCitizenIncident загружает инцидент с перепиской без служебных заметок
+// и историей статусов без причин и авторов.
+func (h *Handler) loadCitizenIncident(incidentID uuid.UUID) (*models.CitizenIncident, error) {
+	var incident models.CitizenIncident
+	var encryptedExcerpt string
+	err := h.DB.QueryRow(`
+		SELECT id, subject, excerpt, status, created_at, status_changed_at
+		FROM incidents
+		WHERE id = $1
+	`, incidentID).Scan(
+		&incident.ID, &incident.Subject, &encryptedExcerpt, &incident.Status, &incident.CreatedAt, &incident.StatusChangedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	excerpt, err := utils.Decrypt(encryptedExcerpt, []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return nil, err
+	}
+	incident.Excerpt = string(excerpt)
+
+	messages, err := h.getIncidentMessages(incidentID)
+	if err != nil {
+		return nil, err
+	}
+	incident.Messages = []models.CitizenIncidentMessage{}
+	for _, m := range messages {
+		if m.Internal {
+			continue
+		}
+		incident.Messages = append(incident.Messages, models.CitizenIncidentMessage{
+			ID:          m.ID,
+			FromCitizen: m.FromCitizen,
+			Message:     m.Message,
+			CreatedAt:   m.CreatedAt,
+		})
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT to_status, created_at
+		FROM incident_status_history
+		WHERE incident_id = $1
+		ORDER BY created_at, id
+	`, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incident.StatusHistory = []models.CitizenStatusChange{}
+	for rows.Next() {
+		var change models.CitizenStatusChange
+		if err := rows.Scan(&change.Status, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		incident.StatusHistory = append(incident.StatusHistory, change)
+	}
+
+	return &incident, rows.Err()
+}
+
+// GetMyIncidents возвращает инциденты, которые текущий пользователь подал,
+// будучи авторизованным.
+func (h *Handler) GetMyIncidents(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, subject, excerpt, status, created_at, status_changed_at
+		FROM incidents
+		WHERE citizen_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидентов"})
+		return
+	}
+	defer rows.Close()
+
+	incidents := []models.CitizenIncident{}
+	for rows.Next() {
+		var incident models.CitizenIncident
+		var encryptedExcerpt string
+		if err := rows.Scan(
+			&incident.ID, &incident.Subject, &encryptedExcerpt, &incident.Status, &incident.CreatedAt, &incident.StatusChangedAt,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных инцидента"})
+			return
+		}
+
+		excerpt, err := utils.Decrypt(encryptedExcerpt, []byte(h.Config.Crypto.Key))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расшифровки описания"})
+			return
+		}
+		incident.Excerpt = string(excerpt)
+		incidents = append(incidents, incident)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидентов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, incidents)
+}
+
+// GetCitizenIncident отдаёт заявителю его инцидент: GET /my/incidents/:id для
+// авторизованного, GET /track/:code для анонимного.
+func (h *Handler) GetCitizenIncident(c *gin.Context) {
+	incidentID, ok := h.citizenIncidentID(c)
+	if !ok {
+		return
+	}
+
+	incident, err := h.loadCitizenIncident(incidentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return
+	}
+
+	c.JSON(http.StatusOK, incident)
+}
+
+// ReplyToCitizenIncident добавляет ответ заявителя, например на уточняющий
+// вопрос сотрудника. В закрытый инцидент написать нельзя.
+func (h *Handler) ReplyToCitizenIncident(c *gin.Context) {
+	incidentID, ok := h.citizenIncidentID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Message string `json:"message" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение не может быть пустым"})
+		return
+	}
+	if utf8.RuneCountInString(req.Message) > h.Config.Chat.MaxMessageLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Сообщение длиннее %d символов", h.Config.Chat.MaxMessageLength)})
+		return
+	}
+
+	encryptedMessage, err := utils.Encrypt([]byte(req.Message), []byte(h.Config.Crypto.Key))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка шифрования сообщения"})
+		return
+	}
+
+	// Анонимный заявитель пишет без sender_id.
+	var senderID *uuid.UUID
+	if userID, _, ok := currentUser(c); ok {
+		senderID = &userID
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения сообщения"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow("SELECT status FROM incidents WHERE id = $1 FOR UPDATE", incidentID).Scan(&status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидента"})
+		return
+	}
+	if status == models.IncidentClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Инцидент закрыт"})
+		return
+	}
+
+	message := models.CitizenIncidentMessage{FromCitizen: true, Message: req.Message}
+	err = tx.QueryRow(`
+		INSERT INTO incident_messages (incident_id, sender_id, message, search_index, from_citizen)
+		VALUES ($1, $2, $3, $4, TRUE)
+		RETURNING id, created_at
+	`,
+		incidentID, senderID, encryptedMessage, pq.Array(utils.BlindIndex(req.Message, []byte(h.Config.Crypto.SearchKey))),
+	).Scan(&message.ID, &message.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения сообщения"})
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE incidents SET unread = true WHERE id = $1", incidentID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса инцидента"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения сообщения"})
+		return
+	}
+
+	h.publishIncidentEvent(incidentMessage, incidentID, message.ID)
+
+	c.JSON(http.StatusCreated, message)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNormalizeTrackingCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcd-efgh-jkmn-pqrs", "abcdefghjkmnpqrs"},
+		{"ABCD-EFGH-JKMN-PQRS", "abcdefghjkmnpqrs"},
+		{"abcd efgh jkmn pqrs", "abcdefghjkmnpqrs"},
+		{" abcd--efgh - jkmn pqrs ", "abcdefghjkmnpqrs"},
+		{"abcdefghjkmnpqrs", "abcdefghjkmnpqrs"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := normalizeTrackingCode(tt.code); got != tt.want {
+				t.Errorf("normalizeTrackingCode(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTrackingCode(t *testing.T) {
+	format := regexp.MustCompile(`^[a-z2-9]{4}-[a-z2-9]{4}-[a-z2-9]{4}-[a-z2-9]{4}$`)
+
+	code, err := generateTrackingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !format.MatchString(code) {
+		t.Errorf("generateTrackingCode() = %q, want xxxx-xxxx-xxxx-xxxx", code)
+	}
+	if normalized := normalizeTrackingCode(code); len(normalized) != trackingCodeGroups*trackingCodeGroupSize {
+		t.Errorf("normalizeTrackingCode(%q) = %q", code, normalized)
+	}
+}
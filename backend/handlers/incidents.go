@@ -81,80 +81,72 @@ func (h *Handler) CreateIncident(c *gin.Context) {
 		return
 	}
 
+	// Инцидент авторизованного пользователя привязывается к нему, анонимный
+	// заявитель получает код отслеживания, который показывается один раз.
+	var citizenID *uuid.UUID
+	var trackingCode string
+	var trackingCodeHash *string
+	if userID, _, ok := currentUser(c); ok {
+		citizenID = &userID
+	} else {
+		trackingCode, err = generateTrackingCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания инцидента"})
+			return
+		}
+		hash := utils.HashToken(normalizeTrackingCode(trackingCode))
+		trackingCodeHash = &hash
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания инцидента"})
+		return
+	}
+	defer tx.Rollback()
+
 	var incidentID uuid.UUID
-	err = h.DB.QueryRow(
-		"INSERT INTO incidents (sender_name, subject, excerpt, tags, media_urls, latitude, longitude) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
-		sender, subject, encryptedExcerpt, pq.Array(tags), pq.Array(mediaURLs), latitude, longitude,
+	err = tx.QueryRow(`
+		INSERT INTO incidents (sender_name, subject, excerpt, search_index, tags, media_urls, latitude, longitude, status,
+			citizen_id, tracking_code_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`,
+		sender, subject, encryptedExcerpt, pq.Array(utils.BlindIndex(subject+" "+excerpt, []byte(h.Config.Crypto.SearchKey))),
+		pq.Array(tags), pq.Array(mediaURLs), latitude, longitude, models.IncidentNew, citizenID, trackingCodeHash,
 	).Scan(&incidentID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания инцидента"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	if _, err := recordIncidentStatus(tx, incidentID, nil, models.IncidentNew, "", nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания инцидента"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания инцидента"})
+		return
+	}
+
+	h.publishIncidentEvent(incidentCreated, incidentID, uuid.Nil)
+
+	response := gin.H{
 		"id":         incidentID,
 		"sender":     sender,
 		"subject":    subject,
 		"media_urls": mediaURLs,
+		"status":     models.IncidentNew,
 		"latitude":   latitude,
 		"longitude":  longitude,
 		"message":    "Инцидент успешно создан",
-	})
-}
-
-func (h *Handler) GetIncidents(c *gin.Context) {
-	rows, err := h.DB.Query(`
-		SELECT id, sender_name, subject, excerpt, created_at, unread, tags, media_urls, latitude, longitude 
-		FROM incidents 
-		ORDER BY created_at DESC
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения инцидентов"})
-		return
 	}
-	defer rows.Close()
-
-	var incidents []models.Incident
-	for rows.Next() {
-		var incident models.Incident
-		var encryptedExcerpt string
-		var createdAt time.Time
-
-		if err := rows.Scan(
-			&incident.ID,
-			&incident.SenderName,
-			&incident.Subject,
-			&encryptedExcerpt,
-			&createdAt,
-			&incident.Unread,
-			pq.Array(&incident.Tags),
-			pq.Array(&incident.MediaURLs),
-			&incident.Latitude,
-			&incident.Longitude,
-		); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных инцидента"})
-			return
-		}
-
-		excerptBytes, err := utils.Decrypt(encryptedExcerpt, []byte(h.Config.Crypto.Key))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расшифровки описания"})
-			return
-		}
-
-		incident.Excerpt = string(excerptBytes)
-		incident.CreatedAt = createdAt
-
-		incident.Messages, err = h.getIncidentMessages(incident.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений инцидента"})
-			return
-		}
-
-		incidents = append(incidents, incident)
+	if trackingCode != "" {
+		response["tracking_code"] = trackingCode
 	}
 
-	c.JSON(http.StatusOK, incidents)
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *Handler) AddIncidentMessage(c *gin.Context) {
@@ -166,8 +158,9 @@ func (h *Handler) AddIncidentMessage(c *gin.Context) {
 	}
 
 	var req struct {
-		Message  string     `json:"message" binding:"required"`
-		SenderID *uuid.UUID `json:"sender_id"`
+		Message string `json:"message" binding:"required"`
+		// Internal — служебная заметка, заявитель её не увидит.
+		Internal bool `json:"internal"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -175,6 +168,12 @@ func (h *Handler) AddIncidentMessage(c *gin.Context) {
 		return
 	}
 
+	senderID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
 	encryptedMessage, err := utils.Encrypt([]byte(req.Message), []byte(h.Config.Crypto.Key))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка шифрования сообщения"})
@@ -183,8 +182,8 @@ func (h *Handler) AddIncidentMessage(c *gin.Context) {
 
 	var messageID uuid.UUID
 	err = h.DB.QueryRow(
-		"INSERT INTO incident_messages (incident_id, sender_id, message) VALUES ($1, $2, $3) RETURNING id",
-		incidentID, req.SenderID, encryptedMessage,
+		"INSERT INTO incident_messages (incident_id, sender_id, message, search_index, internal) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		incidentID, senderID, encryptedMessage, pq.Array(utils.BlindIndex(req.Message, []byte(h.Config.Crypto.SearchKey))), req.Internal,
 	).Scan(&messageID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения сообщения"})
@@ -197,16 +196,19 @@ func (h *Handler) AddIncidentMessage(c *gin.Context) {
 		return
 	}
 
+	h.publishIncidentEvent(incidentMessage, incidentID, messageID)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"id":          messageID,
 		"incident_id": incidentID,
+		"internal":    req.Internal,
 		"message":     "Сообщение успешно добавлено",
 	})
 }
 
 func (h *Handler) getIncidentMessages(incidentID uuid.UUID) ([]models.IncidentMessage, error) {
 	rows, err := h.DB.Query(`
-		SELECT id, incident_id, sender_id, message, created_at 
+		SELECT id, incident_id, sender_id, message, created_at, from_citizen, internal
 		FROM incident_messages 
 		WHERE incident_id = $1 
 		ORDER BY created_at ASC
@@ -228,6 +230,8 @@ func (h *Handler) getIncidentMessages(incidentID uuid.UUID) ([]models.IncidentMe
 			&message.SenderID,
 			&encryptedMessage,
 			&createdAt,
+			&message.FromCitizen,
+			&message.Internal,
 		); err != nil {
 			return nil, err
 		}
@@ -259,5 +263,7 @@ func (h *Handler) MarkIncidentAsRead(c *gin.Context) {
 		return
 	}
 
+	h.publishIncidentEvent(incidentUpdated, incidentID, uuid.Nil)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Инцидент отмечен как прочитанный"})
 }
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"backend/config"
+	"backend/limiter"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// LoginGuards ограничивают подбор пароля и кодов TOTP. Счётчики ведутся
+// отдельно по учётной записи, по IP и по TOTP-коду пользователя.
+// PasswordReset ограничивает запросы сброса пароля по IP и по адресу.
+type LoginGuards struct {
+	Account       *limiter.Guard
+	IP            *limiter.Guard
+	TOTP          *limiter.Guard
+	PasswordReset *limiter.Guard
+}
+
+func newLoginGuards(db *sql.DB, cfg config.SecurityConfig) LoginGuards {
+	var store limiter.Store = limiter.NewMemoryStore()
+	if cfg.LimiterStore == "postgres" {
+		store = limiter.NewPostgresStore(db)
+	}
+
+	lockout := time.Duration(cfg.LoginLockoutMinutes) * time.Minute
+
+	return LoginGuards{
+		Account: limiter.NewGuard(store, limiter.Policy{
+			FreeAttempts:     3,
+			BaseDelay:        time.Second,
+			MaxDelay:         time.Minute,
+			LockoutThreshold: cfg.LoginMaxFailures,
+			LockoutDuration:  lockout,
+			ResetAfter:       time.Hour,
+		}),
+		IP: limiter.NewGuard(store, limiter.Policy{
+			FreeAttempts:     10,
+			BaseDelay:        time.Second,
+			MaxDelay:         5 * time.Minute,
+			LockoutThreshold: cfg.LoginMaxFailures * 10,
+			LockoutDuration:  lockout,
+			ResetAfter:       time.Hour,
+		}),
+		// Для шестизначного кода задержка начинается сразу, а блокировка
+		// наступает раньше, чем для пароля.
+		TOTP: limiter.NewGuard(store, limiter.Policy{
+			FreeAttempts:     1,
+			BaseDelay:        2 * time.Second,
+			MaxDelay:         time.Minute,
+			LockoutThreshold: cfg.TOTPMaxFailures,
+			LockoutDuration:  lockout,
+			ResetAfter:       time.Hour,
+		}),
+		// Засчитывается каждый запрос: после трёх писем следующий можно
+		// отправить через минуту, дальше интервал удваивается.
+		PasswordReset: limiter.NewGuard(store, limiter.Policy{
+			FreeAttempts: 3,
+			BaseDelay:    time.Minute,
+			MaxDelay:     30 * time.Minute,
+			ResetAfter:   time.Hour,
+		}),
+	}
+}
+
+func accountKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func totpKey(userID uuid.UUID) string {
+	return "totp:" + userID.String()
+}
+
+func resetIPKey(ip string) string {
+	return "reset-ip:" + ip
+}
+
+func resetEmailKey(email string) string {
+	return "reset-email:" + strings.ToLower(email)
+}
+
+// reserveAttempt засчитывает попытку до проверки пароля или кода. Если хотя
+// бы один ключ сейчас ограничен, отвечает 429 и возвращает false. Удачная
+// попытка потом снимается через Reset или Release.
+func reserveAttempt(c *gin.Context, guard *limiter.Guard, keys ...string) bool {
+	decision, err := guard.Reserve(c.Request.Context(), keys...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки ограничений входа"})
+		return false
+	}
+
+	if decision.Allowed() {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+	message := "Слишком много попыток, повторите позже"
+	if decision.Locked {
+		message = "Учётная запись временно заблокирована из-за множества неудачных попыток входа"
+	}
+
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retry_after": retryAfter})
+	return false
+}
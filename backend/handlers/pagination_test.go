@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := uuid.MustParse("6f1c2a5e-3b7d-4c1a-9e2f-0a1b2c3d4e5f")
+	almaty := time.FixedZone("Asia/Almaty", 5*60*60)
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+	}{
+		{"UTC", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"наносекунды", time.Date(2024, 3, 1, 10, 0, 0, 123456789, time.UTC)},
+		{"другой часовой пояс", time.Date(2024, 3, 1, 15, 0, 0, 0, almaty)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			createdAt, gotID, err := decodeCursor(encodeCursor(tt.createdAt, id))
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			if !createdAt.Equal(tt.createdAt) || gotID != id {
+				t.Errorf("decodeCursor = %v, %v, want %v, %v", createdAt, gotID, tt.createdAt, id)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"пустой", ""},
+		{"не base64", "!!!"},
+		{"без разделителя", encode("2024-03-01T10:00:00Z")},
+		{"неверное время", encode("вчера|6f1c2a5e-3b7d-4c1a-9e2f-0a1b2c3d4e5f")},
+		{"неверный id", encode("2024-03-01T10:00:00Z|42")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCursor(tt.cursor); err != errInvalidCursor {
+				t.Errorf("decodeCursor(%q) error = %v, want errInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
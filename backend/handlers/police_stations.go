@@ -44,6 +44,21 @@ func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
 	return distance
 }
 
+func nearestPoliceStation(lat, lon float64) (PoliceStation, float64) {
+	var nearestStation PoliceStation
+	minDistance := math.MaxFloat64
+
+	for _, station := range policeStations {
+		distance := calculateDistance(lat, lon, station.Latitude, station.Longitude)
+		if distance < minDistance {
+			minDistance = distance
+			nearestStation = station
+		}
+	}
+
+	return nearestStation, minDistance
+}
+
 func (h *Handler) FindNearestPoliceStation(c *gin.Context) {
 	latStr := c.Query("latitude")
 	lonStr := c.Query("longitude")
@@ -65,16 +80,7 @@ func (h *Handler) FindNearestPoliceStation(c *gin.Context) {
 		return
 	}
 
-	var nearestStation PoliceStation
-	minDistance := math.MaxFloat64
-
-	for _, station := range policeStations {
-		distance := calculateDistance(lat, lon, station.Latitude, station.Longitude)
-		if distance < minDistance {
-			minDistance = distance
-			nearestStation = station
-		}
-	}
+	nearestStation, minDistance := nearestPoliceStation(lat, lon)
 
 	c.JSON(http.StatusOK, gin.H{
 		"station":     nearestStation,
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"backend/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	// wsPresenceHeartbeat — как часто соединение подтверждает, что живо.
+	wsPresenceHeartbeat = 30 * time.Second
+	// wsPresenceTTL — после этого срока без подтверждения соединение
+	// считается оставшимся от упавшего экземпляра.
+	wsPresenceTTL = 3 * wsPresenceHeartbeat
+	// typingThrottle — не чаще одного события «печатает» на беседу от клиента.
+	typingThrottle = 3 * time.Second
+)
+
+const (
+	typingStart = "start"
+	typingStop  = "stop"
+)
+
+// loadPresence возвращает присутствие пользователей ids, а если ids пуст —
+// всех, у кого есть открытые соединения. Пользователь в сети, если хотя бы
+// одно соединение активно, и отошёл, если все его соединения отошли.
+func (h *Handler) loadPresence(ids []uuid.UUID) ([]models.Presence, error) {
+	rows, err := h.DB.Query(`
+		SELECT u.id, u.username, u.role, u.last_seen_at,
+			COUNT(c.id), COALESCE(BOOL_OR(c.status = 'online'), FALSE)
+		FROM users u
+		LEFT JOIN chat_connections c
+			ON c.user_id = u.id AND c.heartbeat_at > NOW() - $3 * INTERVAL '1 second'
+		WHERE ($2 AND u.id = ANY($1)) OR (NOT $2 AND c.id IS NOT NULL)
+		GROUP BY u.id
+		ORDER BY u.username
+	`, pq.Array(ids), len(ids) > 0, int(wsPresenceTTL.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.Presence{}
+	for rows.Next() {
+		var p models.Presence
+		var connections int
+		var online bool
+		if err := rows.Scan(&p.UserID, &p.Username, &p.Role, &p.LastSeenAt, &connections, &online); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case online:
+			p.Status = models.PresenceOnline
+		case connections > 0:
+			p.Status = models.PresenceAway
+		default:
+			p.Status = models.PresenceOffline
+		}
+		result = append(result, p)
+	}
+
+	return result, rows.Err()
+}
+
+func (h *Handler) userPresence(userID uuid.UUID) (models.Presence, error) {
+	list, err := h.loadPresence([]uuid.UUID{userID})
+	if err != nil || len(list) == 0 {
+		return models.Presence{UserID: userID, Status: models.PresenceOffline}, err
+	}
+	return list[0], nil
+}
+
+// changePresence выполняет change и, если от этого изменился статус
+// пользователя, сообщает об этом сотрудникам.
+func (h *Handler) changePresence(userID uuid.UUID, change func() error) error {
+	before, err := h.userPresence(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	after, err := h.userPresence(userID)
+	if err != nil {
+		return err
+	}
+
+	if after.Status != before.Status {
+		event := chatEvent{Type: chatEventPresence, UserID: userID, Status: after.Status}
+		if after.LastSeenAt != nil {
+			event.At = *after.LastSeenAt
+		}
+		h.publishChatEvent(event)
+	}
+	return nil
+}
+
+func (h *Handler) connectPresence(client *WebSocketClient) {
+	err := h.changePresence(*client.UserID, func() error {
+		_, err := h.DB.Exec(
+			"INSERT INTO chat_connections (id, user_id, status) VALUES ($1, $2, $3)",
+			client.ID, client.UserID, models.PresenceOnline,
+		)
+		return err
+	})
+	if err != nil {
+		log.Printf("Ошибка регистрации присутствия: %v", err)
+	}
+}
+
+func (h *Handler) disconnectPresence(client *WebSocketClient) {
+	err := h.changePresence(*client.UserID, func() error {
+		if _, err := h.DB.Exec("DELETE FROM chat_connections WHERE id = $1", client.ID); err != nil {
+			return err
+		}
+		_, err := h.DB.Exec("UPDATE users SET last_seen_at = NOW() WHERE id = $1", client.UserID)
+		return err
+	})
+	if err != nil {
+		log.Printf("Ошибка снятия присутствия: %v", err)
+	}
+}
+
+func (h *Handler) setPresenceStatus(client *WebSocketClient, status string) error {
+	return h.changePresence(*client.UserID, func() error {
+		_, err := h.DB.Exec(
+			"UPDATE chat_connections SET status = $2, heartbeat_at = NOW() WHERE id = $1",
+			client.ID, status,
+		)
+		return err
+	})
+}
+
+// presenceHeartbeat продлевает соединение клиента, пока оно открыто, и
+// заодно удаляет соединения, оставшиеся от упавших экземпляров.
+func (h *Handler) presenceHeartbeat(client *WebSocketClient) {
+	ticker := time.NewTicker(wsPresenceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.closing:
+			return
+		case <-ticker.C:
+			_, err := h.DB.Exec(
+				"UPDATE chat_connections SET heartbeat_at = NOW() WHERE id = $1",
+				client.ID,
+			)
+			if err == nil {
+				_, err = h.DB.Exec(
+					"UPDATE users SET last_seen_at = NOW() WHERE id = $1",
+					client.UserID,
+				)
+			}
+			if err == nil {
+				_, err = h.DB.Exec(
+					"DELETE FROM chat_connections WHERE heartbeat_at < NOW() - $1 * INTERVAL '1 second'",
+					int(wsPresenceTTL.Seconds()),
+				)
+			}
+			if err != nil {
+				log.Printf("Ошибка обновления присутствия: %v", err)
+			}
+		}
+	}
+}
+
+// handleTyping пересылает участникам беседы, что пользователь печатает.
+// Повторные start чаще typingThrottle и stop без start отбрасываются.
+func (h *Handler) handleTyping(client *WebSocketClient, requestID string, p typingPayload) {
+	switch p.State {
+	case typingStart:
+		if last, ok := client.typing[p.ConversationID]; ok && time.Since(last) < typingThrottle {
+			return
+		}
+		if _, err := h.conversationAccess(p.ConversationID, *client.UserID, client.Role); err != nil {
+			client.replyError(requestID, &wsError{Code: wsErrNotFound, Message: "Беседа не найдена"})
+			return
+		}
+		client.typing[p.ConversationID] = time.Now()
+	case typingStop:
+		if _, ok := client.typing[p.ConversationID]; !ok {
+			return
+		}
+		delete(client.typing, p.ConversationID)
+	}
+
+	h.publishTyping(client, p.ConversationID, p.State)
+}
+
+func (h *Handler) publishTyping(client *WebSocketClient, conversationID uuid.UUID, state string) {
+	h.publishChatEvent(chatEvent{
+		Type:           chatEventTyping,
+		ConversationID: conversationID,
+		UserID:         *client.UserID,
+		Status:         state,
+	})
+}
+
+// stopTyping завершает все незакрытые «печатает» клиента при отключении.
+func (h *Handler) stopTyping(client *WebSocketClient) {
+	for conversationID := range client.typing {
+		h.publishTyping(client, conversationID, typingStop)
+	}
+	client.typing = nil
+}
+
+// GetPresence возвращает присутствие пользователей из параметров user_id,
+// а без них — всех, кто сейчас подключён к чату.
+func (h *Handler) GetPresence(c *gin.Context) {
+	var ids []uuid.UUID
+	for _, raw := range c.QueryArray("user_id") {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	presence, err := h.loadPresence(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения статусов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
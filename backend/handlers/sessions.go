@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// refreshGracePeriod — сколько после ротации предыдущий токен обновления
+// ещё возвращает текущую пару. Две вкладки, одновременно обновившие токен,
+// иначе выглядели бы как повторное использование и завершали сессию.
+const refreshGracePeriod = 30 * time.Second
+
+func (h *Handler) accessTokenTTL() time.Duration {
+	return time.Duration(h.Config.JWT.AccessExpireMinutes) * time.Minute
+}
+
+func (h *Handler) refreshTokenTTL() time.Duration {
+	return time.Duration(h.Config.JWT.RefreshExpireHours) * time.Hour
+}
+
+// startSession создаёт новую серверную сессию и выдаёт для неё пару токенов.
+func (h *Handler) startSession(c *gin.Context, user models.User) (*models.TokenResponse, error) {
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionID uuid.UUID
+	err = h.DB.QueryRow(
+		`INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		user.ID, utils.HashToken(refreshToken), truncate(c.Request.UserAgent(), 255), c.ClientIP(),
+		time.Now().Add(h.refreshTokenTTL()),
+	).Scan(&sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.issueTokens(user, sessionID, refreshToken)
+}
+
+func (h *Handler) issueTokens(user models.User, sessionID uuid.UUID, refreshToken string) (*models.TokenResponse, error) {
+	token, err := utils.GenerateToken(h.Keys, user.ID, user.Username, user.Role, sessionID, h.accessTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.accessTokenTTL().Seconds()),
+	}, nil
+}
+
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokenHash := utils.HashToken(req.RefreshToken)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+	defer tx.Rollback()
+
+	var user models.User
+	var sessionID uuid.UUID
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT s.id, s.expires_at, s.revoked_at, u.id, u.username, u.role, u.disabled_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.refresh_token_hash = $1
+		FOR UPDATE OF s
+	`, tokenHash).Scan(&sessionID, &expiresAt, &revokedAt, &user.ID, &user.Username, &user.Role, &user.DisabledAt)
+	if err == sql.ErrNoRows {
+		h.handleRefreshTokenReuse(c, tokenHash)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	if revokedAt.Valid || time.Now().After(expiresAt) || user.DisabledAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия завершена"})
+		return
+	}
+
+	newRefreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	// Новый токен хранится зашифрованным рядом со старым, пока идёт
+	// refreshGracePeriod, потом стирается.
+	successor, err := utils.Encrypt([]byte(newRefreshToken), []byte(h.Config.Crypto.Key))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO session_rotated_tokens (token_hash, session_id, successor) VALUES ($1, $2, $3)",
+		tokenHash, sessionID, successor,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE session_rotated_tokens SET successor = NULL
+		WHERE session_id = $1 AND successor IS NOT NULL AND rotated_at < NOW() - make_interval(secs => $2)
+	`, sessionID, refreshGracePeriod.Seconds())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE sessions SET refresh_token_hash = $1, last_used_at = NOW(), ip_address = $2, user_agent = $3 WHERE id = $4",
+		utils.HashToken(newRefreshToken), c.ClientIP(), truncate(c.Request.UserAgent(), 255), sessionID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	response, err := h.issueTokens(user, sessionID, newRefreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации токена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleRefreshTokenReuse вызывается, когда токен не найден среди действующих.
+// Если это уже использованный при ротации токен, значит он утёк: сессия
+// отзывается целиком, чтобы злоумышленник и владелец оба потеряли доступ.
+// Исключение — первые refreshGracePeriod после ротации: тогда возвращается
+// текущая пара, потому что вероятнее всего это параллельный запрос из
+// другой вкладки.
+func (h *Handler) handleRefreshTokenReuse(c *gin.Context, tokenHash string) {
+	var sessionID uuid.UUID
+	var successor sql.NullString
+	var inGrace bool
+	err := h.DB.QueryRow(`
+		SELECT session_id, successor, rotated_at > NOW() - make_interval(secs => $2)
+		FROM session_rotated_tokens
+		WHERE token_hash = $1
+	`, tokenHash, refreshGracePeriod.Seconds()).Scan(&sessionID, &successor, &inGrace)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен обновления"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+		return
+	}
+
+	if inGrace && successor.Valid {
+		response, err := h.currentSessionTokens(sessionID, successor.String)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления токена"})
+			return
+		}
+		if response != nil {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+	}
+
+	if _, err := h.DB.Exec("UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессии"})
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен обновления"})
+}
+
+// currentSessionTokens выдаёт новый токен доступа вместе с текущим токеном
+// обновления сессии, если encryptedSuccessor всё ещё текущий, а сессия и
+// пользователь активны. Иначе возвращает nil.
+func (h *Handler) currentSessionTokens(sessionID uuid.UUID, encryptedSuccessor string) (*models.TokenResponse, error) {
+	refreshToken, err := utils.Decrypt(encryptedSuccessor, []byte(h.Config.Crypto.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = h.DB.QueryRow(`
+		SELECT u.id, u.username, u.role
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.refresh_token_hash = $2 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+			AND u.disabled_at IS NULL
+	`, sessionID, utils.HashToken(string(refreshToken))).Scan(&user.ID, &user.Username, &user.Role)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return h.issueTokens(user, sessionID, string(refreshToken))
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	_, err := h.DB.Exec(
+		"UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		currentSessionID(c), userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессии"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Сессия завершена"})
+}
+
+func (h *Handler) GetSessions(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессий"})
+		return
+	}
+	defer rows.Close()
+
+	currentID := currentSessionID(c)
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных сессии"})
+			return
+		}
+		s.Current = s.ID == currentID
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	sessionID, err := uuid.Parse(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сессии"})
+		return
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		sessionID, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессии"})
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сессия не найдена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Сессия отозвана"})
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме текущей.
+func (h *Handler) RevokeOtherSessions(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, currentSessionID(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва сессий"})
+		return
+	}
+
+	revoked, _ := result.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{"message": "Остальные сессии отозваны", "revoked": revoked})
+}
+
+// revokeUserSessions завершает все сессии пользователя, например после смены
+// роли или блокировки.
+func (h *Handler) revokeUserSessions(userID uuid.UUID) error {
+	_, err := h.DB.Exec("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// truncate обрезает строку до max символов: VARCHAR в Postgres считает
+// символы, а обрезка по байтам могла бы разрезать многобайтовый символ.
+func truncate(s string, max int) string {
+	runes := 0
+	for i := range s {
+		if runes == max {
+			return s[:i]
+		}
+		runes++
+	}
+	return s
+}
+
+// JWKS публикует открытые ключи, чтобы другие сервисы могли проверять
+// выданные здесь токены.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Keys.JWKS())
+}
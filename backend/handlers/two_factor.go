@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/utils"
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodesCount = 10
+
+func (h *Handler) SetupTwoFactor(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var username string
+	var enabled bool
+	err := h.DB.QueryRow("SELECT username, two_fa_enabled FROM users WHERE id = $1", userID).Scan(&username, &enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Двухфакторная аутентификация уже включена"})
+		return
+	}
+
+	secret, url, err := utils.GenerateTOTPSecret(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации TOTP"})
+		return
+	}
+
+	_, err = h.DB.Exec("UPDATE users SET two_fa_pending_secret = $1 WHERE id = $2", secret, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения TOTP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"totp_url":    url,
+		"totp_secret": secret,
+		"message":     "Отсканируйте код и подтвердите его через /api/auth/2fa/confirm",
+	})
+}
+
+func (h *Handler) ConfirmTwoFactor(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var pendingSecret sql.NullString
+	var lastStep sql.NullInt64
+	err := h.DB.QueryRow(
+		"SELECT two_fa_pending_secret, two_fa_last_step FROM users WHERE id = $1",
+		userID,
+	).Scan(&pendingSecret, &lastStep)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	if !pendingSecret.Valid || pendingSecret.String == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сначала начните подключение через /api/auth/2fa/setup"})
+		return
+	}
+
+	if !reserveAttempt(c, h.Guards.TOTP, totpKey(userID)) {
+		return
+	}
+
+	step, valid := utils.ValidateTOTP(pendingSecret.String, req.Code, lastStep.Int64)
+	if !valid {
+		h.rejectTOTPCode(c)
+		return
+	}
+	if !h.acceptTOTPCode(c, userID) {
+		return
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации кодов восстановления"})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка включения двухфакторной аутентификации"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET two_fa_secret = two_fa_pending_secret, two_fa_pending_secret = NULL,
+			two_fa_enabled = TRUE, two_fa_last_step = $1
+		WHERE id = $2
+	`, step, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка включения двухфакторной аутентификации"})
+		return
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, codes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения кодов восстановления"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка включения двухфакторной аутентификации"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Двухфакторная аутентификация включена",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	userID, _, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	var lastStep sql.NullInt64
+	err := h.DB.QueryRow(
+		"SELECT two_fa_secret, two_fa_enabled, two_fa_last_step FROM users WHERE id = $1",
+		userID,
+	).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+
+	if !enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Двухфакторная аутентификация не включена"})
+		return
+	}
+
+	if !reserveAttempt(c, h.Guards.TOTP, totpKey(userID)) {
+		return
+	}
+
+	valid, err := h.consumeTOTP(userID, secret.String, lastStep.Int64, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки кода"})
+		return
+	}
+	if !valid {
+		h.rejectTOTPCode(c)
+		return
+	}
+	if !h.acceptTOTPCode(c, userID) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отключения двухфакторной аутентификации"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET two_fa_secret = NULL, two_fa_pending_secret = NULL, two_fa_enabled = FALSE, two_fa_last_step = NULL
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отключения двухфакторной аутентификации"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM two_fa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отключения двухфакторной аутентификации"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отключения двухфакторной аутентификации"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация отключена"})
+}
+
+// rejectTOTPCode отвечает 401 на неверный код. Попытка уже засчитана
+// reserveAttempt и остаётся в счётчике.
+func (h *Handler) rejectTOTPCode(c *gin.Context) {
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный код двухфакторной аутентификации"})
+}
+
+// acceptTOTPCode сбрасывает счётчик кодов после верного кода.
+func (h *Handler) acceptTOTPCode(c *gin.Context, userID uuid.UUID) bool {
+	if err := h.Guards.TOTP.Reset(c.Request.Context(), totpKey(userID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка учёта попытки входа"})
+		return false
+	}
+	return true
+}
+
+// consumeTOTP проверяет код и атомарно запоминает его шаг. Если параллельный
+// запрос уже принял код того же или более позднего шага, возвращается false.
+func (h *Handler) consumeTOTP(userID uuid.UUID, secret string, lastStep int64, code string) (bool, error) {
+	step, valid := utils.ValidateTOTP(secret, code, lastStep)
+	if !valid {
+		return false, nil
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE users SET two_fa_last_step = $1 WHERE id = $2 AND (two_fa_last_step IS NULL OR two_fa_last_step < $1)",
+		step, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// consumeRecoveryCode ищет неиспользованный код восстановления и помечает его
+// использованным.
+func (h *Handler) consumeRecoveryCode(userID uuid.UUID, code string) (bool, error) {
+	code = utils.NormalizeRecoveryCode(code)
+
+	codes, err := h.unusedRecoveryCodes(userID)
+	if err != nil {
+		return false, err
+	}
+
+	var matchedID *uuid.UUID
+	for _, stored := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(stored.hash), []byte(code)) == nil {
+			matchedID = &stored.id
+			break
+		}
+	}
+	if matchedID == nil {
+		return false, nil
+	}
+
+	result, err := h.DB.Exec(
+		"UPDATE two_fa_recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL",
+		*matchedID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+type recoveryCode struct {
+	id   uuid.UUID
+	hash string
+}
+
+// unusedRecoveryCodes читает хеши неиспользованных кодов целиком и закрывает
+// выборку до сравнения: bcrypt медленный, и держать на это время соединение
+// из пула незачем.
+func (h *Handler) unusedRecoveryCodes(userID uuid.UUID) ([]recoveryCode, error) {
+	rows, err := h.DB.Query(
+		"SELECT id, code_hash FROM two_fa_recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []recoveryCode
+	for rows.Next() {
+		var code recoveryCode
+		if err := rows.Scan(&code.id, &code.hash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, codes []string) error {
+	if _, err := tx.Exec("DELETE FROM two_fa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO two_fa_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, string(hash),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
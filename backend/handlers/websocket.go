@@ -2,23 +2,26 @@ package handlers
 
 import (
 	"backend/models"
-	"backend/utils"
-	"encoding/json"
+	"context"
 	"log"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-var (
-	clientsMutex sync.Mutex
-)
-
 func (h *Handler) WebSocketHandler(c *gin.Context) {
+	identity, err := h.authenticateWebSocket(c.Request)
+	if err == errWSUnauthorized {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки авторизации"})
+		return
+	}
+
 	conn, err := h.Upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Ошибка обновления до WebSocket: %v", err)
@@ -26,160 +29,157 @@ func (h *Handler) WebSocketHandler(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	userIDStr := c.Query("user_id")
-	userRole := c.Query("role")
-
-	var userID *uuid.UUID
-	if userIDStr != "" {
-		id, err := uuid.Parse(userIDStr)
-		if err == nil {
-			userID = &id
-		}
+	client := newWebSocketClient(conn, identity)
+	client.binary = conn.Subprotocol() == wsProtocolMsgpack
+	if err := h.Hub.register(client); err != nil {
+		closeWebSocket(conn, websocket.CloseGoingAway, "server shutdown")
+		return
 	}
-
-	clientID := uuid.New().String()
-	client := &WebSocketClient{
-		Conn:   conn,
-		UserID: userID,
-		Role:   userRole,
+	defer h.Hub.unregister(client)
+	defer h.disconnectPresence(client)
+	defer h.stopTyping(client)
+	defer client.Close(websocket.CloseNormalClosure, "")
+
+	h.connectPresence(client)
+
+	go client.writePump()
+	go h.watchWebSocketSession(client, identity)
+	go h.presenceHeartbeat(client)
+
+	// Кадр больше лимита gorilla/websocket не дочитывает и закрывает
+	// соединение с кодом 1009.
+	conn.SetReadLimit(h.Config.Chat.MaxFrameBytes)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	// since — курсор последнего полученного сообщения. Всё, что пришло
+	// после него, пока клиент был отключён, отправляется сразу после подключения.
+	if since := c.Query("since"); since != "" {
+		h.replayMessages(client, "", since)
 	}
 
-	clientsMutex.Lock()
-	h.Clients[clientID] = client
-	clientsMutex.Unlock()
-
-	defer func() {
-		clientsMutex.Lock()
-		delete(h.Clients, clientID)
-		clientsMutex.Unlock()
-	}()
-
 	for {
-		_, message, err := conn.ReadMessage()
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Ошибка чтения: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Ошибка чтения: %v", err)
+			}
 			break
 		}
 
-		var chatMessage struct {
-			RecipientID *uuid.UUID `json:"recipient_id"`
-			Message     string     `json:"message"`
-		}
-		if err := json.Unmarshal(message, &chatMessage); err != nil {
-			log.Printf("Ошибка парсинга сообщения: %v", err)
+		if client.binary != (messageType == websocket.BinaryMessage) {
+			client.replyError("", &wsError{Code: wsErrInvalidFrame, Message: "Тип кадра не соответствует подпротоколу"})
 			continue
 		}
+		if client.binary {
+			if data, err = msgpackToJSON(data); err != nil {
+				client.replyError("", &wsError{Code: wsErrInvalidFrame, Message: "Неверный формат кадра"})
+				continue
+			}
+		}
 
-		encryptedMessage, err := utils.Encrypt([]byte(chatMessage.Message), []byte(h.Config.Crypto.Key))
-		if err != nil {
-			log.Printf("Ошибка шифрования сообщения: %v", err)
+		env, frameErr := decodeEnvelope(data)
+		if frameErr != nil {
+			client.replyError(env.ID, frameErr)
 			continue
 		}
 
-		var messageID uuid.UUID
-		err = h.DB.QueryRow(
-			"INSERT INTO chat_messages (sender_id, recipient_id, message) VALUES ($1, $2, $3) RETURNING id",
-			userID, chatMessage.RecipientID, encryptedMessage,
-		).Scan(&messageID)
-		if err != nil {
-			log.Printf("Ошибка сохранения сообщения: %v", err)
-			continue
+		h.handleFrame(client, env)
+	}
+}
+
+// handleFrame проверяет данные кадра клиента и выполняет его.
+func (h *Handler) handleFrame(client *WebSocketClient, env *wsEnvelope) {
+	cfg := h.Config.Chat
+
+	switch env.Type {
+	case wsTypeChatSend:
+		var p chatSendPayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
+			return
 		}
+		h.handleChatSend(client, env.ID, p)
 
-		response := models.ChatMessage{
-			ID:          messageID,
-			SenderID:    userID,
-			RecipientID: chatMessage.RecipientID,
-			Message:     chatMessage.Message,
-			CreatedAt:   time.Now(),
+	case wsTypeChatDelivered:
+		var p chatDeliveredPayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
+			return
+		}
+		if err := h.markDelivered(*client.UserID, client.Role, p.MessageIDs); err != nil {
+			log.Printf("Ошибка отметки о доставке: %v", err)
+			client.replyError(env.ID, internalError("Ошибка отметки о доставке"))
 		}
 
-		responseJSON, err := json.Marshal(response)
-		if err != nil {
-			log.Printf("Ошибка сериализации ответа: %v", err)
-			continue
+	case wsTypeChatRead:
+		var p chatReadPayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
+			return
+		}
+		if _, err := h.conversationAccess(p.ConversationID, *client.UserID, client.Role); err != nil {
+			client.replyError(env.ID, &wsError{Code: wsErrNotFound, Message: "Беседа не найдена"})
+			return
+		}
+		if err := h.markConversationRead(p.ConversationID, *client.UserID); err != nil {
+			log.Printf("Ошибка отметки о прочтении: %v", err)
+			client.replyError(env.ID, internalError("Ошибка отметки о прочтении"))
 		}
 
-		clientsMutex.Lock()
-		for id, client := range h.Clients {
-			if userRole == "citizen" {
-				if client.Role == "police" {
-					if err := client.Conn.WriteMessage(1, responseJSON); err != nil {
-						log.Printf("Ошибка отправки: %v", err)
-					}
-				}
-			} else if userRole == "police" {
-				if (chatMessage.RecipientID != nil && client.UserID != nil && *client.UserID == *chatMessage.RecipientID) ||
-					(client.Role == "police" && id != clientID) {
-					if err := client.Conn.WriteMessage(1, responseJSON); err != nil {
-						log.Printf("Ошибка отправки: %v", err)
-					}
-				}
-			}
+	case wsTypeChatReplay:
+		var p chatReplayPayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
+			return
 		}
-		clientsMutex.Unlock()
-	}
-}
+		h.replayMessages(client, env.ID, p.Cursor)
 
-func (h *Handler) GetChatMessages(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	var userID *uuid.UUID
-	if userIDStr != "" {
-		id, err := uuid.Parse(userIDStr)
-		if err == nil {
-			userID = &id
+	case wsTypeTyping:
+		var p typingPayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
+			return
 		}
-	}
+		h.handleTyping(client, env.ID, p)
 
-	rows, err := h.DB.Query(`
-		SELECT id, sender_id, recipient_id, message, created_at 
-		FROM chat_messages 
-		WHERE sender_id = $1 OR recipient_id = $1 OR recipient_id IS NULL
-		ORDER BY created_at ASC
-	`, userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сообщений"})
-		return
-	}
-	defer rows.Close()
-
-	var messages []models.ChatMessage
-	for rows.Next() {
-		var message models.ChatMessage
-		var encryptedMessage string
-		var createdAt time.Time
-
-		if err := rows.Scan(
-			&message.ID,
-			&message.SenderID,
-			&message.RecipientID,
-			&encryptedMessage,
-			&createdAt,
-		); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения данных сообщения"})
+	case wsTypePresence:
+		var p presencePayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
 			return
 		}
+		if err := h.setPresenceStatus(client, p.Status); err != nil {
+			log.Printf("Ошибка смены статуса: %v", err)
+			client.replyError(env.ID, internalError("Ошибка смены статуса"))
+		}
 
-		messageBytes, err := utils.Decrypt(encryptedMessage, []byte(h.Config.Crypto.Key))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расшифровки сообщения"})
+	case wsTypeIncidentSubscribe:
+		if !models.RoleHasPermission(client.Role, models.PermissionIncidentsRead) {
+			client.replyError(env.ID, &wsError{Code: wsErrForbidden, Message: "Недостаточно прав"})
 			return
 		}
+		var p incidentSubscribePayload
+		if err := decodePayload(env, &p, cfg); err != nil {
+			client.replyError(env.ID, err)
+			return
+		}
+		client.setIncidentFilter(incidentFilter{StationIDs: p.StationIDs, Tags: p.Tags})
 
-		message.Message = string(messageBytes)
-		message.CreatedAt = createdAt
-		messages = append(messages, message)
+	default:
+		client.replyError(env.ID, &wsError{Code: wsErrUnknownType, Message: "Неизвестный тип кадра"})
 	}
-
-	c.JSON(http.StatusOK, messages)
 }
 
-func (h *Handler) StartWebSocketServer() {
-	log.Println("WebSocket сервер запущен")
-}
-
-type WebSocketClient struct {
-	Conn   *websocket.Conn
-	UserID *uuid.UUID
-	Role   string
+// Shutdown останавливает фоновые задачи, закрывает все WebSocket-соединения,
+// ждёт завершения их обработчиков и отключается от шины.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	close(h.stop)
+	err := h.Hub.Shutdown(ctx)
+	if closeErr := h.Bus.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
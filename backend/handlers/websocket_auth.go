@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsTicketTTL            = 30 * time.Second
+	wsSessionCheckInterval = 30 * time.Second
+	// wsBearerProtocol — подпротокол, которым клиент передаёт токен:
+	// new WebSocket(url, ["bearer", token]).
+	wsBearerProtocol = "bearer"
+)
+
+var errWSUnauthorized = errors.New("websocket: unauthorized")
+
+// wsIdentity — пользователь, от имени которого открыто соединение.
+type wsIdentity struct {
+	UserID    uuid.UUID
+	Role      string
+	SessionID uuid.UUID
+	ExpiresAt time.Time
+}
+
+// CreateWebSocketTicket выдаёт короткоживущий билет для подключения к /ws/chat
+// или /api/events.
+// Браузер не умеет передавать заголовок Authorization при открытии сокета,
+// поэтому короткоживущий билет передаётся в параметре ticket.
+func (h *Handler) CreateWebSocketTicket(c *gin.Context) {
+	userID, role, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+		return
+	}
+
+	ticket, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания билета"})
+		return
+	}
+
+	_, err = h.DB.Exec(
+		`INSERT INTO ws_tickets (ticket_hash, user_id, session_id, role, token_expires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		utils.HashToken(ticket), userID, currentSessionID(c), role,
+		c.GetTime("token_expires_at"), time.Now().Add(wsTicketTTL),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания билета"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(wsTicketTTL.Seconds()),
+	})
+}
+
+// authenticateWebSocket определяет пользователя по билету из параметра
+// ticket или по токену из подпротокола bearer.
+func (h *Handler) authenticateWebSocket(r *http.Request) (*wsIdentity, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return h.consumeWebSocketTicket(ticket)
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == wsBearerProtocol {
+			return h.identityFromToken(protocols[i+1])
+		}
+	}
+
+	return nil, errWSUnauthorized
+}
+
+// authenticateEventStream определяет пользователя потока /api/events по
+// билету из параметра ticket (EventSource не передаёт заголовки) или по
+// заголовку Authorization.
+func (h *Handler) authenticateEventStream(r *http.Request) (*wsIdentity, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return h.useEventStreamTicket(ticket)
+	}
+
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errWSUnauthorized
+	}
+	return h.identityFromToken(parts[1])
+}
+
+func (h *Handler) identityFromToken(token string) (*wsIdentity, error) {
+	claims, err := utils.ValidateToken(token, h.Keys)
+	if err != nil {
+		return nil, errWSUnauthorized
+	}
+
+	active, err := h.sessionActive(claims.SessionID, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, errWSUnauthorized
+	}
+
+	return &wsIdentity{
+		UserID:    claims.UserID,
+		Role:      claims.Role,
+		SessionID: claims.SessionID,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+func (h *Handler) consumeWebSocketTicket(ticket string) (*wsIdentity, error) {
+	return h.ticketIdentity(`
+		UPDATE ws_tickets SET used_at = NOW()
+		WHERE ticket_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, role, session_id, token_expires_at
+	`, ticket)
+}
+
+// useEventStreamTicket в отличие от consumeWebSocketTicket пускает по
+// одному билету повторно, пока он не истёк: EventSource после обрыва сам
+// переподключается по тому же адресу с тем же ?ticket=. Билет, уже
+// использованный для WebSocket, поток не принимает, и наоборот.
+func (h *Handler) useEventStreamTicket(ticket string) (*wsIdentity, error) {
+	return h.ticketIdentity(`
+		UPDATE ws_tickets SET used_at = COALESCE(used_at, NOW()), event_stream = TRUE
+		WHERE ticket_hash = $1 AND (used_at IS NULL OR event_stream) AND expires_at > NOW()
+		RETURNING user_id, role, session_id, token_expires_at
+	`, ticket)
+}
+
+// ticketIdentity отмечает билет запросом query и проверяет, что сессия, для
+// которой он выдан, ещё активна.
+func (h *Handler) ticketIdentity(query, ticket string) (*wsIdentity, error) {
+	var identity wsIdentity
+	err := h.DB.QueryRow(query, utils.HashToken(ticket)).Scan(
+		&identity.UserID, &identity.Role, &identity.SessionID, &identity.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errWSUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := h.sessionActive(identity.SessionID, identity.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, errWSUnauthorized
+	}
+
+	return &identity, nil
+}
+
+func (h *Handler) sessionActive(sessionID, userID uuid.UUID) (bool, error) {
+	var active bool
+	err := h.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM sessions s
+			JOIN users u ON u.id = s.user_id
+			WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+				AND u.disabled_at IS NULL
+		)
+	`, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// watchWebSocketSession закрывает соединение, когда истекает токен, которым
+// оно было открыто, или когда сессию отзывают. Завершается вместе с клиентом.
+func (h *Handler) watchWebSocketSession(client *WebSocketClient, identity *wsIdentity) {
+	expiry := time.NewTimer(time.Until(identity.ExpiresAt))
+	defer expiry.Stop()
+
+	ticker := time.NewTicker(wsSessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.closing:
+			return
+		case <-expiry.C:
+			client.Close(websocket.ClosePolicyViolation, "token expired")
+			return
+		case <-ticker.C:
+			active, err := h.sessionActive(identity.SessionID, identity.UserID)
+			if err != nil {
+				log.Printf("Ошибка проверки сессии WebSocket: %v", err)
+				continue
+			}
+			if !active {
+				client.Close(websocket.ClosePolicyViolation, "session revoked")
+				return
+			}
+		}
+	}
+}
+
+// closeWebSocket отправляет кадр закрытия и закрывает соединение.
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	deadline := time.Now().Add(time.Second)
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	conn.Close()
+}
+
+// checkOrigin разрешает подключение без заголовка Origin (не из браузера),
+// с адресов из списка и, если список пуст, только с того же хоста.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		if len(allowed) == 0 {
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		}
+
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
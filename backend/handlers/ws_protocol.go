@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"backend/config"
+	"backend/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Протокол /ws/chat. Каждый кадр — конверт
+//
+//	{"type": "chat.send", "id": "…", "version": 1, "payload": {…}}
+//
+// id задаёт отправитель кадра; сервер указывает его в request_id ответа
+// error. По умолчанию кадры передаются текстом в JSON. Клиент, предложивший
+// подпротокол msgpack, получает и отправляет те же конверты двоичными
+// кадрами в MessagePack.
+const wsProtocolVersion = 1
+
+const (
+	wsProtocolJSON    = "json"
+	wsProtocolMsgpack = "msgpack"
+)
+
+// Кадры клиента.
+const (
+	wsTypeChatSend      = "chat.send"
+	wsTypeChatDelivered = "chat.delivered"
+	wsTypeChatRead      = "chat.read"
+	wsTypeChatReplay    = "chat.replay"
+	// wsTypeIncidentSubscribe ограничивает ленту инцидентов участками и тегами.
+	wsTypeIncidentSubscribe = "incident.subscribe"
+)
+
+// Кадры сервера.
+const (
+	wsTypeChatMessage     = "chat.message"
+	wsTypeChatAck         = "chat.ack"
+	wsTypeChatReceipt     = "chat.receipt"
+	wsTypeChatReplayDone  = "chat.replay_done"
+	wsTypeChatEdited      = "chat.edited"
+	wsTypeChatDeleted     = "chat.deleted"
+	wsTypeChatAssigned    = "chat.assigned"
+	wsTypeIncidentCreated = "incident.created"
+	wsTypeIncidentMessage = "incident.message"
+	wsTypeIncidentUpdated = "incident.updated"
+	wsTypeError           = "error"
+)
+
+// Кадры, которые отправляют обе стороны.
+const (
+	wsTypeTyping   = "typing"
+	wsTypePresence = "presence"
+)
+
+// Коды ошибок в кадре error.
+const (
+	wsErrInvalidFrame       = "invalid_frame"
+	wsErrUnsupportedVersion = "unsupported_version"
+	wsErrUnknownType        = "unknown_type"
+	wsErrInvalidPayload     = "invalid_payload"
+	wsErrNotFound           = "not_found"
+	wsErrForbidden          = "forbidden"
+	wsErrInternal           = "internal"
+)
+
+// wsMaxMessageIDs ограничивает число сообщений в одном chat.delivered.
+const wsMaxMessageIDs = 100
+
+type wsEnvelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsError — ошибка разбора или обработки кадра, которую получает клиент.
+type wsError struct {
+	Code    string
+	Message string
+}
+
+func (e *wsError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type chatSendPayload struct {
+	// ClientMessageID присваивает клиент, чтобы повторная отправка после
+	// обрыва связи не создавала дубликат.
+	ClientMessageID uuid.UUID  `json:"client_message_id"`
+	ConversationID  *uuid.UUID `json:"conversation_id,omitempty"`
+	RecipientID     *uuid.UUID `json:"recipient_id,omitempty"`
+	Message         string     `json:"message"`
+	// AttachmentIDs — вложения, загруженные заранее в ту же беседу.
+	AttachmentIDs []uuid.UUID `json:"attachment_ids,omitempty"`
+}
+
+func (p *chatSendPayload) validate(cfg config.ChatConfig) error {
+	if p.ClientMessageID == uuid.Nil {
+		return errors.New("Не указан client_message_id")
+	}
+	if p.Message == "" && len(p.AttachmentIDs) == 0 {
+		return errors.New("Пустое сообщение")
+	}
+	if len(p.AttachmentIDs) > chatMaxAttachments {
+		return fmt.Errorf("Не больше %d вложений в сообщении", chatMaxAttachments)
+	}
+	if utf8.RuneCountInString(p.Message) > cfg.MaxMessageLength {
+		return fmt.Errorf("Сообщение длиннее %d символов", cfg.MaxMessageLength)
+	}
+	return nil
+}
+
+type chatDeliveredPayload struct {
+	MessageIDs []uuid.UUID `json:"message_ids"`
+}
+
+func (p *chatDeliveredPayload) validate(cfg config.ChatConfig) error {
+	if len(p.MessageIDs) == 0 {
+		return errors.New("Не указаны сообщения")
+	}
+	if len(p.MessageIDs) > wsMaxMessageIDs {
+		return fmt.Errorf("Не больше %d сообщений за раз", wsMaxMessageIDs)
+	}
+	return nil
+}
+
+type chatReadPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+func (p *chatReadPayload) validate(cfg config.ChatConfig) error {
+	if p.ConversationID == uuid.Nil {
+		return errors.New("Не указана беседа")
+	}
+	return nil
+}
+
+type chatReplayPayload struct {
+	Cursor string `json:"cursor"`
+}
+
+func (p *chatReplayPayload) validate(cfg config.ChatConfig) error {
+	if _, _, err := decodeCursor(p.Cursor); err != nil {
+		return errors.New("Неверный курсор")
+	}
+	return nil
+}
+
+type typingPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	// State — start или stop.
+	State string `json:"state"`
+}
+
+func (p *typingPayload) validate(cfg config.ChatConfig) error {
+	if p.ConversationID == uuid.Nil {
+		return errors.New("Не указана беседа")
+	}
+	if p.State != typingStart && p.State != typingStop {
+		return errors.New("Неизвестное состояние набора")
+	}
+	return nil
+}
+
+type presencePayload struct {
+	// Status — online или away.
+	Status string `json:"status"`
+}
+
+func (p *presencePayload) validate(cfg config.ChatConfig) error {
+	if p.Status != models.PresenceOnline && p.Status != models.PresenceAway {
+		return errors.New("Неизвестный статус")
+	}
+	return nil
+}
+
+type chatMessagePayload struct {
+	Message *models.ChatMessage `json:"message"`
+	// Cursor — позиция сообщения, с которой клиент продолжит после переподключения.
+	Cursor string `json:"cursor"`
+}
+
+// chatDeletedPayload — заглушка удалённого сообщения.
+type chatDeletedPayload struct {
+	MessageID      uuid.UUID  `json:"message_id"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	DeletedAt      *time.Time `json:"deleted_at"`
+	Redacted       bool       `json:"redacted"`
+}
+
+type chatAckPayload struct {
+	ClientMessageID *uuid.UUID          `json:"client_message_id"`
+	Message         *models.ChatMessage `json:"message"`
+	Cursor          string              `json:"cursor"`
+}
+
+type chatReceiptPayload struct {
+	UserID     uuid.UUID   `json:"user_id"`
+	Status     string      `json:"status"`
+	MessageIDs []uuid.UUID `json:"message_ids"`
+	At         time.Time   `json:"at"`
+}
+
+type chatReplayDonePayload struct {
+	Cursor  string `json:"cursor"`
+	HasMore bool   `json:"has_more"`
+}
+
+type typingEventPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	State          string    `json:"state"`
+}
+
+type presenceEventPayload struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+type errorPayload struct {
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// encodeFrame собирает JSON-конверт кадра сервера.
+func encodeFrame(frameType string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(wsEnvelope{
+		Type:    frameType,
+		ID:      uuid.NewString(),
+		Version: wsProtocolVersion,
+		Payload: data,
+	})
+}
+
+// decodeEnvelope разбирает конверт кадра клиента. Неизвестные поля, лишние
+// данные после конверта и неподдерживаемая версия считаются ошибкой.
+func decodeEnvelope(data []byte) (*wsEnvelope, *wsError) {
+	var env wsEnvelope
+	if err := decodeStrict(data, &env); err != nil {
+		return &env, &wsError{Code: wsErrInvalidFrame, Message: "Неверный формат кадра"}
+	}
+	if env.Type == "" {
+		return &env, &wsError{Code: wsErrInvalidFrame, Message: "Не указан тип кадра"}
+	}
+	if env.Version != wsProtocolVersion {
+		return &env, &wsError{
+			Code:    wsErrUnsupportedVersion,
+			Message: fmt.Sprintf("Поддерживается версия протокола %d", wsProtocolVersion),
+		}
+	}
+	return &env, nil
+}
+
+type wsPayload interface {
+	validate(cfg config.ChatConfig) error
+}
+
+func decodePayload(env *wsEnvelope, payload wsPayload, cfg config.ChatConfig) *wsError {
+	if len(env.Payload) == 0 {
+		return &wsError{Code: wsErrInvalidPayload, Message: "Не указаны данные кадра"}
+	}
+	if err := decodeStrict(env.Payload, payload); err != nil {
+		return &wsError{Code: wsErrInvalidPayload, Message: "Неверные данные кадра"}
+	}
+	if err := payload.validate(cfg); err != nil {
+		return &wsError{Code: wsErrInvalidPayload, Message: err.Error()}
+	}
+	return nil
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after frame")
+	}
+	return nil
+}
+
+// msgpackToJSON переводит кадр клиента из MessagePack в JSON, чтобы дальше
+// он разбирался так же, как текстовый.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonToMsgpack переводит кадр сервера в MessagePack. Целые числа остаются
+// целыми, идентификаторы и даты — строками, как в JSON.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(normalizeJSONNumbers(v))
+}
+
+func normalizeJSONNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = normalizeJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeJSONNumbers(item)
+		}
+	}
+	return v
+}
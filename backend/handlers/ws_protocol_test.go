@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestMsgpackJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+	}{
+		{"конверт", `{"type":"chat.send","id":"1","version":1,"payload":{"message":"Привет"}}`},
+		{"целые числа", `{"limit":50,"offset":-3,"big":9007199254740993}`},
+		{"дробные числа", `{"latitude":43.238949,"longitude":76.889709}`},
+		{"вложенные массивы", `{"message_ids":["a","b"],"matrix":[[1,2],[3]]}`},
+		{"null и bool", `{"edited_at":null,"unread":true,"deleted":false}`},
+		{"пустые значения", `{"tags":[],"payload":{},"text":""}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packed, err := jsonToMsgpack([]byte(tt.frame))
+			if err != nil {
+				t.Fatalf("jsonToMsgpack: %v", err)
+			}
+			data, err := msgpackToJSON(packed)
+			if err != nil {
+				t.Fatalf("msgpackToJSON: %v", err)
+			}
+
+			var want, got interface{}
+			if err := json.Unmarshal([]byte(tt.frame), &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("результат не JSON: %v (%s)", err, data)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("после перевода туда и обратно %s, want %s", data, tt.frame)
+			}
+		})
+	}
+}
+
+func TestJSONToMsgpackKeepsIntegers(t *testing.T) {
+	packed, err := jsonToMsgpack([]byte(`{"version":1,"count":-7,"ratio":0.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]interface{}
+	if err := msgpack.Unmarshal(packed, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key     string
+		integer bool
+	}{
+		{"version", true},
+		{"count", true},
+		{"ratio", false},
+	}
+	for _, tt := range tests {
+		if got := isInteger(v[tt.key]); got != tt.integer {
+			t.Errorf("%s = %#v, целое: %v, want %v", tt.key, v[tt.key], got, tt.integer)
+		}
+	}
+}
+
+func isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return true
+	}
+	return false
+}
+
+func TestMsgpackToJSONRejectsGarbage(t *testing.T) {
+	if _, err := msgpackToJSON([]byte{0xc1}); err == nil {
+		t.Error("msgpackToJSON приняла неверные данные")
+	}
+}
@@ -0,0 +1,133 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Record — счётчик попыток для одного ключа. LastFailure — время последней
+// засчитанной попытки.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store хранит счётчики попыток. Реализация в памяти подходит для одного
+// экземпляра сервиса, Postgres — когда экземпляров несколько.
+type Store interface {
+	// Reserve атомарно читает счётчик ключа, передаёт его allow и, если
+	// попытка разрешена, сразу засчитывает её. Так параллельные запросы не
+	// проходят проверку все разом до того, как учтена хотя бы одна ошибка.
+	// Если с последней попытки прошло больше resetAfter, счёт начинается
+	// заново. now в allow — текущее время по часам хранилища, тем же, по
+	// которым записан LastFailure.
+	Reserve(ctx context.Context, key string, resetAfter time.Duration, allow func(record Record, now time.Time) Decision) (Decision, error)
+	// Release возвращает одну засчитанную попытку, не трогая время последней.
+	Release(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+type Policy struct {
+	// FreeAttempts — сколько ошибок допускается без задержки.
+	FreeAttempts int
+	// BaseDelay удваивается с каждой следующей ошибкой, но не больше MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// После LockoutThreshold ошибок ключ блокируется на LockoutDuration.
+	// Счётчик после блокировки не сбрасывается, поэтому следующая ошибка
+	// сразу блокирует ключ снова.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// ResetAfter — через сколько без ошибок счётчик забывается.
+	ResetAfter time.Duration
+}
+
+type Decision struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (d Decision) Allowed() bool {
+	return d.RetryAfter <= 0
+}
+
+type Guard struct {
+	store  Store
+	policy Policy
+}
+
+func NewGuard(store Store, policy Policy) *Guard {
+	return &Guard{store: store, policy: policy}
+}
+
+// Reserve засчитывает попытку по всем ключам до её проверки. Если хотя бы
+// один ключ сейчас ограничен, возвращается его ограничение, а попытка по
+// нему не засчитывается. Успешная попытка затем снимается через Reset или
+// Release, неудачная остаётся в счётчике.
+func (g *Guard) Reserve(ctx context.Context, keys ...string) (Decision, error) {
+	for _, key := range keys {
+		d, err := g.store.Reserve(ctx, key, g.policy.ResetAfter, g.decide)
+		if err != nil {
+			return Decision{}, err
+		}
+		if !d.Allowed() {
+			return d, nil
+		}
+	}
+	return Decision{}, nil
+}
+
+// Release снимает засчитанную попытку, не сбрасывая счётчик: так удачный
+// вход не обнуляет ошибки, накопленные по общему ключу, например по IP.
+func (g *Guard) Release(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := g.store.Release(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Guard) Reset(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := g.store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Guard) decide(record Record, now time.Time) Decision {
+	if record.Failures == 0 {
+		return Decision{}
+	}
+
+	if now.Sub(record.LastFailure) > g.policy.ResetAfter {
+		return Decision{}
+	}
+
+	if g.policy.LockoutThreshold > 0 && record.Failures >= g.policy.LockoutThreshold {
+		until := record.LastFailure.Add(g.policy.LockoutDuration)
+		if now.Before(until) {
+			return Decision{RetryAfter: until.Sub(now), Locked: true}
+		}
+		return Decision{}
+	}
+
+	excess := record.Failures - g.policy.FreeAttempts
+	if excess <= 0 {
+		return Decision{}
+	}
+
+	delay := time.Duration(float64(g.policy.BaseDelay) * math.Pow(2, float64(excess-1)))
+	if delay > g.policy.MaxDelay || delay <= 0 {
+		delay = g.policy.MaxDelay
+	}
+
+	until := record.LastFailure.Add(delay)
+	if now.Before(until) {
+		return Decision{RetryAfter: until.Sub(now)}
+	}
+	return Decision{}
+}
@@ -0,0 +1,127 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGuardDecide(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewGuard(NewMemoryStore(), Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 20,
+		LockoutDuration:  15 * time.Minute,
+		ResetAfter:       time.Hour,
+	})
+
+	tests := []struct {
+		name       string
+		failures   int
+		ago        time.Duration
+		retryAfter time.Duration
+		locked     bool
+	}{
+		{"без ошибок", 0, 0, 0, false},
+		{"бесплатные попытки", 3, 0, 0, false},
+		{"первая задержка", 4, 0, time.Second, false},
+		{"задержка удваивается", 6, 0, 4 * time.Second, false},
+		{"задержка прошла", 4, 2 * time.Second, 0, false},
+		{"часть задержки прошла", 6, time.Second, 3 * time.Second, false},
+		{"задержка не больше MaxDelay", 10, 0, time.Minute, false},
+		{"блокировка", 20, time.Minute, 14 * time.Minute, true},
+		{"блокировка после порога", 25, 0, 15 * time.Minute, true},
+		{"блокировка истекла", 20, 16 * time.Minute, 0, false},
+		{"счётчик забыт", 25, 2 * time.Hour, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := g.decide(Record{Failures: tt.failures, LastFailure: now.Add(-tt.ago)}, now)
+			if d.RetryAfter != tt.retryAfter || d.Locked != tt.locked {
+				t.Errorf("decide(%d ошибок %v назад) = %+v, want RetryAfter %v, Locked %v",
+					tt.failures, tt.ago, d, tt.retryAfter, tt.locked)
+			}
+		})
+	}
+}
+
+func TestGuardReserve(t *testing.T) {
+	ctx := context.Background()
+	g := NewGuard(NewMemoryStore(), Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Hour,
+		MaxDelay:     time.Hour,
+		ResetAfter:   24 * time.Hour,
+	})
+
+	steps := []struct {
+		name    string
+		action  func() (Decision, error)
+		allowed bool
+	}{
+		{"первая попытка", reserve(ctx, g, "a"), true},
+		{"вторая попытка", reserve(ctx, g, "a"), true},
+		{"третья попытка", reserve(ctx, g, "a"), true},
+		{"четвёртая ждёт", reserve(ctx, g, "a"), false},
+		{"другой ключ не ограничен", reserve(ctx, g, "b"), true},
+		{"после Release", release(ctx, g, "a"), true},
+		{"Release возвращает одну попытку", reserve(ctx, g, "a"), false},
+		{"ограниченный ключ останавливает проверку", reserve(ctx, g, "a", "c"), false},
+		{"после Reset", reset(ctx, g, "a"), true},
+	}
+
+	for _, step := range steps {
+		d, err := step.action()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if d.Allowed() != step.allowed {
+			t.Fatalf("%s: Allowed() = %v, want %v", step.name, d.Allowed(), step.allowed)
+		}
+	}
+
+	// Ключ c не засчитывался: его счётчик пуст.
+	record, err := peek(ctx, g.store, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Failures != 0 {
+		t.Errorf("по ключу c засчитано %d попыток, want 0", record.Failures)
+	}
+}
+
+func reserve(ctx context.Context, g *Guard, keys ...string) func() (Decision, error) {
+	return func() (Decision, error) { return g.Reserve(ctx, keys...) }
+}
+
+// release и reset снимают попытки и затем пробуют ещё раз по тому же ключу.
+func release(ctx context.Context, g *Guard, key string) func() (Decision, error) {
+	return func() (Decision, error) {
+		if err := g.Release(ctx, key); err != nil {
+			return Decision{}, err
+		}
+		return g.Reserve(ctx, key)
+	}
+}
+
+func reset(ctx context.Context, g *Guard, key string) func() (Decision, error) {
+	return func() (Decision, error) {
+		if err := g.Reset(ctx, key); err != nil {
+			return Decision{}, err
+		}
+		return g.Reserve(ctx, key)
+	}
+}
+
+// peek читает счётчик, ничего не засчитывая.
+func peek(ctx context.Context, store Store, key string) (Record, error) {
+	var record Record
+	_, err := store.Reserve(ctx, key, time.Hour, func(r Record, _ time.Time) Decision {
+		record = r
+		return Decision{RetryAfter: time.Hour}
+	})
+	return record, err
+}
@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memorySweepEvery = 1000
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	ops     int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, resetAfter time.Duration, allow func(Record, time.Time) Decision) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.ops++
+	if s.ops%memorySweepEvery == 0 {
+		s.sweep(now, resetAfter)
+	}
+
+	record := s.records[key]
+	if now.Sub(record.LastFailure) > resetAfter {
+		record = Record{}
+	}
+
+	d := allow(record, now)
+	if !d.Allowed() {
+		return d, nil
+	}
+
+	record.Failures++
+	record.LastFailure = now
+	s.records[key] = record
+	return d, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[key]; ok && record.Failures > 0 {
+		record.Failures--
+		s.records[key] = record
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// sweep удаляет давно забытые счётчики, чтобы карта не росла бесконечно.
+func (s *MemoryStore) sweep(now time.Time, resetAfter time.Duration) {
+	for key, record := range s.records {
+		if now.Sub(record.LastFailure) > resetAfter {
+			delete(s.records, key)
+		}
+	}
+}
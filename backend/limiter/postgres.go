@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore хранит счётчики в таблице login_attempts, общей для всех
+// экземпляров сервиса.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Reserve блокирует строку ключа на время решения, поэтому параллельные
+// попытки с разных экземпляров засчитываются по очереди. Текущее время
+// берётся из базы вместе со счётчиком: last_failure записан по её часам, а
+// часы экземпляров сервиса могут расходиться с ними.
+func (s *PostgresStore) Reserve(ctx context.Context, key string, resetAfter time.Duration, allow func(Record, time.Time) Decision) (Decision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 0, NOW()) ON CONFLICT (key) DO NOTHING",
+		key,
+	)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	var record Record
+	var now time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT failures, last_failure, NOW() FROM login_attempts WHERE key = $1 FOR UPDATE",
+		key,
+	).Scan(&record.Failures, &record.LastFailure, &now)
+	if err != nil {
+		return Decision{}, err
+	}
+	if now.Sub(record.LastFailure) > resetAfter {
+		record = Record{}
+	}
+
+	d := allow(record, now)
+	if !d.Allowed() {
+		return d, tx.Commit()
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE login_attempts SET failures = $2, last_failure = NOW() WHERE key = $1",
+		key, record.Failures+1,
+	)
+	if err != nil {
+		return Decision{}, err
+	}
+	return d, tx.Commit()
+}
+
+func (s *PostgresStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE login_attempts SET failures = GREATEST(failures - 1, 0) WHERE key = $1",
+		key,
+	)
+	return err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"backend/commands"
 	"backend/config"
 	"backend/database"
 	"backend/handlers"
 	"backend/middleware"
 	"backend/routes"
+	"context"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -23,18 +32,54 @@ func main() {
 	}
 	defer db.Close()
 
+	if len(os.Args) > 1 {
+		if err := commands.Run(os.Args[1:], db, cfg); err != nil {
+			log.Fatalf("Ошибка выполнения команды: %v", err)
+		}
+		return
+	}
+
 	router := gin.Default()
+	// По умолчанию gin верит X-Forwarded-For от любого клиента, и подменой
+	// заголовка можно обойти ограничения по IP.
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Неверный TRUSTED_PROXIES: %v", err)
+	}
 
-	h := handlers.NewHandler(db)
+	h, err := handlers.NewHandler(db, cfg)
+	if err != nil {
+		log.Fatalf("Ошибка инициализации обработчиков: %v", err)
+	}
 
 	middleware.SetupMiddleware(router)
 
 	routes.SetupRoutes(router, h)
 
-	go h.StartWebSocketServer()
+	srv := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: router,
+	}
+
+	go func() {
+		log.Printf("Сервер запущен на порту %s", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Ошибка запуска сервера: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
 
-	log.Printf("Сервер запущен на порту %s", cfg.Server.Port)
-	if err := router.Run(":" + cfg.Server.Port); err != nil {
-		log.Fatalf("Ошибка запуска сервера: %v", err)
+	log.Println("Остановка сервера...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Сначала закрываются потоки событий: иначе srv.Shutdown ждал бы их до таймаута.
+	if err := h.Shutdown(ctx); err != nil {
+		log.Printf("Ошибка закрытия WebSocket-соединений: %v", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Ошибка остановки HTTP-сервера: %v", err)
 	}
 }
@@ -1,8 +1,9 @@
 package middleware
 
 import (
-	"backend/config"
+	"backend/models"
 	"backend/utils"
+	"database/sql"
 	"net/http"
 	"strings"
 
@@ -20,7 +21,7 @@ func corsMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -31,9 +32,7 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-func AuthMiddleware() gin.HandlerFunc {
-	cfg, _ := config.LoadConfig()
-
+func AuthMiddleware(db *sql.DB, keys *utils.KeySet) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -49,15 +48,98 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := utils.ValidateToken(parts[1], cfg.JWT.Secret)
+		claims, err := utils.ValidateToken(parts[1], keys)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен"})
 			c.Abort()
 			return
 		}
 
+		var mustChangePassword bool
+		err = db.QueryRow(`
+			SELECT u.must_change_password
+			FROM sessions s
+			JOIN users u ON u.id = s.user_id
+			WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+				AND u.disabled_at IS NULL
+		`, claims.SessionID, claims.UserID).Scan(&mustChangePassword)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия завершена"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки сессии"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
+		c.Set("session_id", claims.SessionID)
+		c.Set("token_expires_at", claims.ExpiresAt)
+		c.Set("must_change_password", mustChangePassword)
+
+		c.Next()
+	}
+}
+
+// OptionalAuth проверяет токен так же, как AuthMiddleware, но только если он
+// передан: запрос без заголовка Authorization проходит анонимно.
+func OptionalAuth(db *sql.DB, keys *utils.KeySet) gin.HandlerFunc {
+	auth := AuthMiddleware(db, keys)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		auth(c)
+	}
+}
+
+// RequirePasswordChanged не пускает пользователя дальше, пока он не сменит
+// временный пароль, выданный администратором.
+func RequirePasswordChanged() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("must_change_password") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Необходимо сменить временный пароль"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole пропускает запрос, только если роль из токена входит в список
+// разрешённых. Должен подключаться после AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		c.Abort()
+	}
+}
+
+// RequirePermission пропускает запрос, только если у токена есть указанное
+// разрешение. Должен подключаться после AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions := c.GetStringSlice("permissions")
+		if !models.HasPermission(permissions, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
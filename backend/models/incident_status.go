@@ -0,0 +1,60 @@
+package models
+
+const (
+	IncidentNew          = "new"
+	IncidentAcknowledged = "acknowledged"
+	IncidentInProgress   = "in_progress"
+	IncidentResolved     = "resolved"
+	IncidentRejected     = "rejected"
+	IncidentDuplicate    = "duplicate"
+	IncidentClosed       = "closed"
+)
+
+// incidentTransitions — допустимые переходы статуса инцидента. Возврат из
+// resolved, rejected, duplicate и closed в работу — повторное открытие.
+var incidentTransitions = map[string][]string{
+	IncidentNew:          {IncidentAcknowledged, IncidentRejected, IncidentDuplicate},
+	IncidentAcknowledged: {IncidentInProgress, IncidentRejected, IncidentDuplicate},
+	IncidentInProgress:   {IncidentResolved, IncidentRejected, IncidentDuplicate},
+	IncidentResolved:     {IncidentClosed, IncidentInProgress},
+	IncidentRejected:     {IncidentClosed, IncidentAcknowledged},
+	IncidentDuplicate:    {IncidentClosed, IncidentAcknowledged},
+	IncidentClosed:       {IncidentAcknowledged},
+}
+
+func IsValidIncidentStatus(status string) bool {
+	_, ok := incidentTransitions[status]
+	return ok
+}
+
+func IncidentTransitionAllowed(from, to string) bool {
+	for _, status := range incidentTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenIncidentStatuses — статусы инцидентов, которые ещё в работе.
+func OpenIncidentStatuses() []string {
+	return []string{IncidentNew, IncidentAcknowledged, IncidentInProgress}
+}
+
+// IsIncidentFinished — инцидент больше не в работе.
+func IsIncidentFinished(status string) bool {
+	switch status {
+	case IncidentResolved, IncidentRejected, IncidentDuplicate, IncidentClosed:
+		return true
+	}
+	return false
+}
+
+// IncidentTransitionNeedsReason — отказ, отметка дубликата и повторное
+// открытие требуют объяснения.
+func IncidentTransitionNeedsReason(from, to string) bool {
+	if to == IncidentRejected || to == IncidentDuplicate {
+		return true
+	}
+	return IsIncidentFinished(from) && !IsIncidentFinished(to)
+}
@@ -0,0 +1,59 @@
+package models
+
+import "testing"
+
+func TestIncidentTransitionAllowed(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{IncidentNew, IncidentAcknowledged, true},
+		{IncidentNew, IncidentInProgress, false},
+		{IncidentNew, IncidentResolved, false},
+		{IncidentAcknowledged, IncidentInProgress, true},
+		{IncidentInProgress, IncidentResolved, true},
+		{IncidentInProgress, IncidentNew, false},
+		{IncidentResolved, IncidentClosed, true},
+		{IncidentResolved, IncidentInProgress, true},
+		{IncidentRejected, IncidentAcknowledged, true},
+		{IncidentDuplicate, IncidentClosed, true},
+		{IncidentClosed, IncidentAcknowledged, true},
+		{IncidentClosed, IncidentNew, false},
+		{IncidentClosed, IncidentClosed, false},
+		{"unknown", IncidentAcknowledged, false},
+		{IncidentNew, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := IncidentTransitionAllowed(tt.from, tt.to); got != tt.want {
+				t.Errorf("IncidentTransitionAllowed(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncidentTransitionNeedsReason(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{IncidentNew, IncidentAcknowledged, false},
+		{IncidentNew, IncidentRejected, true},
+		{IncidentAcknowledged, IncidentDuplicate, true},
+		{IncidentInProgress, IncidentResolved, false},
+		{IncidentResolved, IncidentClosed, false},
+		// Повторное открытие
+		{IncidentResolved, IncidentInProgress, true},
+		{IncidentRejected, IncidentAcknowledged, true},
+		{IncidentClosed, IncidentAcknowledged, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := IncidentTransitionNeedsReason(tt.from, tt.to); got != tt.want {
+				t.Errorf("IncidentTransitionNeedsReason(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
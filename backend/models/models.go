@@ -7,13 +7,17 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	Email        string    `json:"email"`
-	Role         string    `json:"role"`
-	TwoFASecret  string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID                 uuid.UUID  `json:"id"`
+	Username           string     `json:"username"`
+	PasswordHash       string     `json:"-"`
+	Email              string     `json:"email"`
+	Role               string     `json:"role"`
+	TwoFASecret        string     `json:"-"`
+	TwoFAEnabled       bool       `json:"two_fa_enabled"`
+	DisabledAt         *time.Time `json:"disabled_at,omitempty"`
+	MustChangePassword bool       `json:"must_change_password"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
 }
 
 type News struct {
@@ -25,17 +29,55 @@ type News struct {
 }
 
 type Incident struct {
-	ID         uuid.UUID         `json:"id"`
-	SenderName string            `json:"sender_name"`
-	Subject    string            `json:"subject"`
-	Excerpt    string            `json:"excerpt"`
-	CreatedAt  time.Time         `json:"created_at"`
-	Unread     bool              `json:"unread"`
-	Tags       []string          `json:"tags"`
-	MediaURLs  []string          `json:"media_urls"`
-	Latitude   *float64          `json:"latitude,omitempty"`
-	Longitude  *float64          `json:"longitude,omitempty"`
-	Messages   []IncidentMessage `json:"messages,omitempty"`
+	ID         uuid.UUID `json:"id"`
+	SenderName string    `json:"sender_name"`
+	Subject    string    `json:"subject"`
+	Excerpt    string    `json:"excerpt"`
+	CreatedAt  time.Time `json:"created_at"`
+	Unread     bool      `json:"unread"`
+	Status     string    `json:"status"`
+	// AssignedOfficerID и StationID — кто и какой участок ведёт инцидент.
+	AssignedOfficerID *uuid.UUID        `json:"assigned_officer_id"`
+	StationID         *int              `json:"station_id"`
+	Tags              []string          `json:"tags"`
+	MediaURLs         []string          `json:"media_urls"`
+	Latitude          *float64          `json:"latitude,omitempty"`
+	Longitude         *float64          `json:"longitude,omitempty"`
+	Messages          []IncidentMessage `json:"messages,omitempty"`
+}
+
+type IncidentStatusChange struct {
+	ID         uuid.UUID  `json:"id"`
+	IncidentID uuid.UUID  `json:"incident_id"`
+	FromStatus *string    `json:"from_status"`
+	ToStatus   string     `json:"to_status"`
+	Reason     string     `json:"reason,omitempty"`
+	ChangedBy  *uuid.UUID `json:"changed_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type UpdateIncidentStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// AssignIncidentRequest заменяет назначение целиком: пустое поле снимает
+// назначение.
+type AssignIncidentRequest struct {
+	OfficerID *uuid.UUID `json:"officer_id"`
+	StationID *int       `json:"station_id"`
+}
+
+type OfficerWorkload struct {
+	OfficerID uuid.UUID `json:"officer_id"`
+	Username  string    `json:"username"`
+	Open      int       `json:"open"`
+}
+
+type StationWorkload struct {
+	StationID int    `json:"station_id"`
+	Name      string `json:"name"`
+	Open      int    `json:"open"`
 }
 
 type IncidentMessage struct {
@@ -44,29 +86,252 @@ type IncidentMessage struct {
 	SenderID   *uuid.UUID `json:"sender_id"`
 	Message    string     `json:"message"`
 	CreatedAt  time.Time  `json:"created_at"`
+	// FromCitizen — ответ заявителя; Internal — служебная заметка, которую
+	// заявитель не видит.
+	FromCitizen bool `json:"from_citizen"`
+	Internal    bool `json:"internal"`
+}
+
+// CitizenIncident — инцидент глазами заявителя: без назначения, тегов и
+// служебных заметок.
+type CitizenIncident struct {
+	ID              uuid.UUID                `json:"id"`
+	Subject         string                   `json:"subject"`
+	Excerpt         string                   `json:"excerpt"`
+	Status          string                   `json:"status"`
+	CreatedAt       time.Time                `json:"created_at"`
+	StatusChangedAt *time.Time               `json:"status_changed_at"`
+	Messages        []CitizenIncidentMessage `json:"messages,omitempty"`
+	StatusHistory   []CitizenStatusChange    `json:"status_history,omitempty"`
+}
+
+type CitizenIncidentMessage struct {
+	ID          uuid.UUID `json:"id"`
+	FromCitizen bool      `json:"from_citizen"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CitizenStatusChange struct {
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type ChatMessage struct {
-	ID          uuid.UUID  `json:"id"`
-	SenderID    *uuid.UUID `json:"sender_id"`
-	RecipientID *uuid.UUID `json:"recipient_id"`
-	Message     string     `json:"message"`
-	CreatedAt   time.Time  `json:"created_at"`
+	ID              uuid.UUID        `json:"id"`
+	ConversationID  *uuid.UUID       `json:"conversation_id"`
+	SenderID        *uuid.UUID       `json:"sender_id"`
+	RecipientID     *uuid.UUID       `json:"recipient_id"`
+	ClientMessageID *uuid.UUID       `json:"client_message_id,omitempty"`
+	Message         string           `json:"message"`
+	CreatedAt       time.Time        `json:"created_at"`
+	Attachments     []ChatAttachment `json:"attachments,omitempty"`
+	EditedAt        *time.Time       `json:"edited_at,omitempty"`
+	// DeletedAt задан у удалённых сообщений: от них остаётся только
+	// заглушка без текста и вложений. Redacted — удалено модератором.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Redacted  bool       `json:"redacted,omitempty"`
+	// Receipts заполняется только для сообщений текущего пользователя.
+	Receipts []MessageReceipt `json:"receipts,omitempty"`
+}
+
+const (
+	AttachmentImage    = "image"
+	AttachmentDocument = "document"
+	AttachmentVoice    = "voice"
+)
+
+// ChatAttachment — файл, загруженный в беседу. До отправки сообщения
+// вложение видит только загрузивший его пользователь.
+type ChatAttachment struct {
+	ID       uuid.UUID `json:"id"`
+	Kind     string    `json:"kind"`
+	FileName string    `json:"file_name"`
+	MimeType string    `json:"mime_type"`
+	Size     int64     `json:"size"`
+	// Checksum — SHA-256 содержимого в hex.
+	Checksum string `json:"checksum"`
+	Width    *int   `json:"width,omitempty"`
+	Height   *int   `json:"height,omitempty"`
+	// DurationMs — длительность голосового сообщения со слов клиента.
+	DurationMs *int      `json:"duration_ms,omitempty"`
+	URL        string    `json:"url"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const (
+	RevisionEdit   = "edit"
+	RevisionDelete = "delete"
+	RevisionRedact = "redact"
+)
+
+// ChatMessageRevision — прежний текст сообщения до правки, удаления или
+// скрытия модератором.
+type ChatMessageRevision struct {
+	ID        uuid.UUID  `json:"id"`
+	MessageID uuid.UUID  `json:"message_id"`
+	Action    string     `json:"action"`
+	Message   string     `json:"message"`
+	ActorID   *uuid.UUID `json:"actor_id"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MessageReceipt — состояние доставки сообщения одному получателю.
+type MessageReceipt struct {
+	UserID      uuid.UUID  `json:"user_id"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at"`
+}
+
+const (
+	// ConversationDepartment — обращение гражданина в полицию. Его видят все
+	// сотрудники, а не только участники.
+	ConversationDepartment = "department"
+	ConversationDirect     = "direct"
+	ConversationGroup      = "group"
+)
+
+type Conversation struct {
+	ID        uuid.UUID  `json:"id"`
+	Kind      string     `json:"kind"`
+	Title     string     `json:"title,omitempty"`
+	CitizenID *uuid.UUID `json:"citizen_id,omitempty"`
+	// AssignedTo — сотрудник, который ведёт обращение гражданина.
+	AssignedTo  *uuid.UUID           `json:"assigned_to,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Members     []ConversationMember `json:"members"`
+	LastMessage *ChatMessage         `json:"last_message,omitempty"`
+	UnreadCount int                  `json:"unread_count"`
+}
+
+// ConversationTransfer — передача обращения другому сотруднику. FromUserID
+// пуст при первом назначении, TransferredBy — при автоматическом.
+type ConversationTransfer struct {
+	ID             uuid.UUID  `json:"id"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	FromUserID     *uuid.UUID `json:"from_user_id"`
+	ToUserID       *uuid.UUID `json:"to_user_id"`
+	TransferredBy  *uuid.UUID `json:"transferred_by"`
+	Note           string     `json:"note,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type TransferConversationRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+	Note   string    `json:"note"`
+}
+
+// CannedResponse — шаблон ответа гражданину. Без владельца шаблон общий для
+// департамента.
+type CannedResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	OwnerID   *uuid.UUID `json:"owner_id"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type CannedResponseRequest struct {
+	Title string `json:"title" binding:"required"`
+	Body  string `json:"body" binding:"required"`
+	// Shared — общий шаблон департамента; создавать и менять такие может
+	// только модератор. Если поле не передано, новый шаблон личный, а у
+	// изменяемого остаётся прежний владелец.
+	Shared *bool `json:"shared"`
+}
+
+type ConversationMember struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+}
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+type Presence struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+type CreateConversationRequest struct {
+	Kind      string      `json:"kind" binding:"required"`
+	Title     string      `json:"title"`
+	MemberIDs []uuid.UUID `json:"member_ids"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
-	TOTPCode string `json:"totp_code"`
+	Username     string `json:"username" binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
+	Role     string `json:"role"`
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
 	Role     string `json:"role" binding:"required"`
+	Password string `json:"password"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
 }
 
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token              string `json:"token"`
+	RefreshToken       string `json:"refresh_token"`
+	ExpiresIn          int    `json:"expires_in"`
+	MustChangePassword bool   `json:"must_change_password,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
 }
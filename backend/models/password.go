@@ -0,0 +1,13 @@
+package models
+
+import "unicode/utf8"
+
+// MinPasswordLength — наименьшая длина пароля в символах. Правило общее для
+// API и команды create-admin.
+const MinPasswordLength = 8
+
+const PasswordRequirements = "Пароль должен содержать не менее 8 символов"
+
+func ValidPassword(password string) bool {
+	return utf8.RuneCountInString(password) >= MinPasswordLength
+}
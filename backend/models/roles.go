@@ -0,0 +1,82 @@
+package models
+
+const (
+	RoleCitizen = "citizen"
+	RolePolice  = "police"
+	// RoleSupervisor — старший смены: сотрудник полиции, который может
+	// модерировать чат.
+	RoleSupervisor = "supervisor"
+	RoleAdmin      = "admin"
+)
+
+const (
+	PermissionNewsWrite       = "news:write"
+	PermissionIncidentsRead   = "incidents:read"
+	PermissionIncidentsManage = "incidents:manage"
+	PermissionChatHistory     = "chat:history"
+	PermissionUsersManage     = "users:manage"
+	PermissionChatModerate    = "chat:moderate"
+)
+
+var rolePermissions = map[string][]string{
+	RoleCitizen: {},
+	RolePolice: {
+		PermissionNewsWrite,
+		PermissionIncidentsRead,
+		PermissionIncidentsManage,
+		PermissionChatHistory,
+	},
+	RoleSupervisor: {
+		PermissionNewsWrite,
+		PermissionIncidentsRead,
+		PermissionIncidentsManage,
+		PermissionChatHistory,
+		PermissionChatModerate,
+	},
+	RoleAdmin: {
+		PermissionNewsWrite,
+		PermissionIncidentsRead,
+		PermissionIncidentsManage,
+		PermissionChatHistory,
+		PermissionChatModerate,
+		PermissionUsersManage,
+	},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// PermissionsForRole возвращает копию списка разрешений роли, чтобы его
+// нельзя было изменить через токен.
+func PermissionsForRole(role string) []string {
+	permissions := rolePermissions[role]
+	result := make([]string, len(permissions))
+	copy(result, permissions)
+	return result
+}
+
+func RoleHasPermission(role, permission string) bool {
+	return HasPermission(rolePermissions[role], permission)
+}
+
+// RolesWithPermission возвращает роли, которым выдано разрешение.
+func RolesWithPermission(permission string) []string {
+	var roles []string
+	for role, permissions := range rolePermissions {
+		if HasPermission(permissions, permission) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func HasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
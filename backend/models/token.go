@@ -1,10 +1,16 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type TokenClaims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Username string    `json:"username"`
+	UserID      uuid.UUID `json:"user_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	Permissions []string  `json:"permissions"`
+	SessionID   uuid.UUID `json:"sid"`
+	ExpiresAt   time.Time `json:"exp"`
 }
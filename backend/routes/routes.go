@@ -3,6 +3,7 @@ package routes
 import (
 	"backend/handlers"
 	"backend/middleware"
+	"backend/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +18,10 @@ func SetupRoutes(router *gin.Engine, h *handlers.Handler) {
 		{
 			auth.POST("/register", h.Register)
 			auth.POST("/login", h.Login)
+			auth.POST("/refresh", h.RefreshToken)
+			auth.POST("/password/forgot", h.ForgotPassword)
+			auth.POST("/password/reset", h.ResetPassword)
+			auth.POST("/email/verify", h.VerifyEmail)
 		}
 
 		news := api.Group("/news")
@@ -26,22 +31,93 @@ func SetupRoutes(router *gin.Engine, h *handlers.Handler) {
 
 		incidents := api.Group("/incidents")
 		{
-			incidents.POST("", h.CreateIncident)
+			incidents.POST("", middleware.OptionalAuth(h.DB, h.Keys), h.CreateIncident)
+		}
+
+		track := api.Group("/track")
+		{
+			track.GET("/:code", h.GetCitizenIncident)
+			track.POST("/:code/messages", h.ReplyToCitizenIncident)
+		}
+
+		account := api.Group("/auth")
+		account.Use(middleware.AuthMiddleware(h.DB, h.Keys))
+		{
+			account.POST("/logout", h.Logout)
+			account.POST("/password/change", h.ChangePassword)
 		}
 
 		secured := api.Group("")
-		secured.Use(middleware.AuthMiddleware())
+		secured.Use(middleware.AuthMiddleware(h.DB, h.Keys), middleware.RequirePasswordChanged())
 		{
-			secured.POST("/news", h.CreateNews)
+			secured.POST("/auth/email/verify/resend", h.ResendVerificationEmail)
+			secured.GET("/auth/sessions", h.GetSessions)
+			secured.DELETE("/auth/sessions", h.RevokeOtherSessions)
+			secured.DELETE("/auth/sessions/:session_id", h.RevokeSession)
+			secured.POST("/auth/2fa/setup", h.SetupTwoFactor)
+			secured.POST("/auth/2fa/confirm", h.ConfirmTwoFactor)
+			secured.POST("/auth/2fa/disable", h.DisableTwoFactor)
+
+			secured.POST("/news", middleware.RequirePermission(models.PermissionNewsWrite), h.CreateNews)
 
-			secured.GET("/incidents", h.GetIncidents)
-			secured.POST("/incidents/:incident_id/messages", h.AddIncidentMessage)
-			secured.PUT("/incidents/:incident_id/read", h.MarkIncidentAsRead)
+			secured.GET("/incidents", middleware.RequirePermission(models.PermissionIncidentsRead), h.GetIncidents)
+			secured.GET("/incidents/:incident_id", middleware.RequirePermission(models.PermissionIncidentsRead), h.GetIncident)
+			secured.POST("/incidents/:incident_id/messages", middleware.RequirePermission(models.PermissionIncidentsManage), h.AddIncidentMessage)
+			secured.PUT("/incidents/:incident_id/read", middleware.RequirePermission(models.PermissionIncidentsManage), h.MarkIncidentAsRead)
+			secured.PATCH("/incidents/:incident_id/status", middleware.RequirePermission(models.PermissionIncidentsManage), h.UpdateIncidentStatus)
+			secured.GET("/incidents/:incident_id/status-history", middleware.RequirePermission(models.PermissionIncidentsRead), h.GetIncidentStatusHistory)
+			secured.PUT("/incidents/:incident_id/assignment", middleware.RequirePermission(models.PermissionIncidentsManage), h.AssignIncident)
+			secured.GET("/incidents/workload", middleware.RequirePermission(models.PermissionIncidentsRead), h.GetIncidentWorkload)
 
-			secured.GET("/chat/messages", h.GetChatMessages)
+			secured.GET("/my/incidents", h.GetMyIncidents)
+			secured.GET("/my/incidents/:incident_id", h.GetCitizenIncident)
+			secured.POST("/my/incidents/:incident_id/messages", h.ReplyToCitizenIncident)
+
+			secured.POST("/ws/ticket", h.CreateWebSocketTicket)
+			secured.POST("/chat/messages", h.SendChatMessage)
+			secured.PATCH("/chat/messages/:message_id", h.EditChatMessage)
+			secured.DELETE("/chat/messages/:message_id", h.DeleteChatMessage)
+			secured.POST("/chat/messages/:message_id/redact", middleware.RequirePermission(models.PermissionChatModerate), h.RedactChatMessage)
+			secured.GET("/chat/conversations", h.GetConversations)
+			secured.POST("/chat/conversations", h.CreateConversation)
+			secured.GET("/chat/conversations/:conversation_id/messages", h.GetConversationMessages)
+			secured.POST("/chat/conversations/:conversation_id/read", h.MarkConversationAsRead)
+			secured.POST("/chat/conversations/:conversation_id/attachments", h.UploadChatAttachment)
+			secured.GET("/chat/attachments/:attachment_id", h.GetChatAttachment)
+			secured.GET("/chat/presence", middleware.RequirePermission(models.PermissionChatHistory), h.GetPresence)
+			secured.POST("/chat/conversations/:conversation_id/transfer", middleware.RequirePermission(models.PermissionChatHistory), h.TransferConversation)
+			secured.GET("/chat/conversations/:conversation_id/transfers", middleware.RequirePermission(models.PermissionChatHistory), h.GetConversationTransfers)
+			secured.GET("/chat/conversations/:conversation_id/export", middleware.RequirePermission(models.PermissionChatHistory), h.ExportConversation)
+
+			canned := secured.Group("/chat/canned-responses")
+			canned.Use(middleware.RequirePermission(models.PermissionChatHistory))
+			{
+				canned.GET("", h.GetCannedResponses)
+				canned.POST("", h.CreateCannedResponse)
+				canned.PUT("/:response_id", h.UpdateCannedResponse)
+				canned.DELETE("/:response_id", h.DeleteCannedResponse)
+				canned.GET("/:response_id/render", h.RenderCannedResponse)
+			}
+		}
+
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthMiddleware(h.DB, h.Keys), middleware.RequirePasswordChanged(), middleware.RequireRole(models.RoleAdmin))
+		{
+			admin.POST("/users", h.CreateUser)
+			admin.GET("/users", h.ListUsers)
+			admin.PUT("/users/:user_id/role", h.UpdateUserRole)
+			admin.POST("/users/:user_id/disable", h.DisableUser)
+			admin.POST("/users/:user_id/enable", h.EnableUser)
+			admin.POST("/users/:user_id/reset-password", h.ResetUserPassword)
+			admin.POST("/users/:user_id/unlock", h.UnlockUser)
+			admin.GET("/chat/messages/:message_id/history", h.GetChatMessageHistory)
 		}
 		api.GET("/police-stations/nearest", h.FindNearestPoliceStation)
+
+		api.GET("/events", h.EventStream)
 	}
 
+	router.GET("/.well-known/jwks.json", h.JWKS)
+
 	router.GET("/ws/chat", h.WebSocketHandler)
 }
@@ -2,43 +2,88 @@ package utils
 
 import (
 	"backend/models"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 )
 
-func GenerateToken(userID uuid.UUID, username string, secret string, expireHours int) (string, error) {
+var ErrInvalidClaims = errors.New("invalid token claims")
+
+func GenerateToken(keys *KeySet, userID uuid.UUID, username string, role string, sessionID uuid.UUID, ttl time.Duration) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
-		"user_id":  userID.String(),
-		"username": username,
-		"exp":      time.Now().Add(time.Hour * time.Duration(expireHours)).Unix(),
+		"iss":         keys.Issuer,
+		"sub":         userID.String(),
+		"iat":         now.Unix(),
+		"user_id":     userID.String(),
+		"username":    username,
+		"role":        role,
+		"permissions": models.PermissionsForRole(role),
+		"sid":         sessionID.String(),
+		"exp":         now.Add(ttl).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	token := jwt.NewWithClaims(keys.signing.method, claims)
+	token.Header["kid"] = keys.signing.id
+	return token.SignedString(keys.signing.private)
 }
 
-func ValidateToken(tokenString string, secret string) (*models.TokenClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+func ValidateToken(tokenString string, keys *KeySet) (*models.TokenClaims, error) {
+	token, err := jwt.Parse(tokenString, keys.lookup, jwt.WithValidMethods(allowedAlgorithms))
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID, err := uuid.Parse(claims["user_id"].(string))
-		if err != nil {
-			return nil, err
-		}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidClaims
+	}
+
+	if !claims.VerifyIssuer(keys.Issuer, true) || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, ErrInvalidClaims
+	}
+
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, err
+	}
 
-		return &models.TokenClaims{
-			UserID:   userID,
-			Username: claims["username"].(string),
-		}, nil
+	sessionIDStr, _ := claims["sid"].(string)
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		return nil, ErrInvalidClaims
+	}
+
+	var expiresAt time.Time
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+	if !models.IsValidRole(role) {
+		return nil, ErrInvalidClaims
+	}
+
+	var permissions []string
+	if raw, ok := claims["permissions"].([]interface{}); ok {
+		for _, p := range raw {
+			if s, ok := p.(string); ok {
+				permissions = append(permissions, s)
+			}
+		}
 	}
 
-	return nil, err
+	return &models.TokenClaims{
+		UserID:      userID,
+		Username:    username,
+		Role:        role,
+		Permissions: permissions,
+		SessionID:   sessionID,
+		ExpiresAt:   expiresAt,
+	}, nil
 }
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// allowedAlgorithms — единственные алгоритмы, которые принимает ValidateToken.
+// HS256 и "none" отклоняются ещё до поиска ключа.
+var allowedAlgorithms = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet содержит ключ, которым подписываются новые токены, и все ключи, по
+// которым ещё принимаются ранее выданные. Это позволяет менять ключ подписи,
+// не завершая действующие сессии.
+type KeySet struct {
+	Issuer  string
+	signing *signingKey
+	keys    map[string]*signingKey
+}
+
+// LoadKeySet читает ключи из каталога. Имя файла без расширения служит kid:
+//
+//	2026-10.pem      — закрытый ключ RSA или Ed25519 (PKCS#8 или PKCS#1);
+//	2026-04.pub.pem  — открытый ключ выведенного из оборота ключа, только для проверки.
+//
+// Подписывает ключ signingKID, а если он не задан — закрытый ключ с
+// наибольшим в лексикографическом порядке kid.
+func LoadKeySet(dir, signingKID, issuer string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{Issuer: issuer, keys: make(map[string]*signingKey)}
+	var privateIDs []string
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: не найден PEM-блок", file)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), ".pem")
+		kid := strings.TrimSuffix(name, ".pub")
+
+		var key *signingKey
+		if kid == name {
+			key, err = parsePrivateKey(kid, block)
+			privateIDs = append(privateIDs, kid)
+		} else {
+			key, err = parsePublicKey(kid, block)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		if _, exists := ks.keys[kid]; exists {
+			return nil, fmt.Errorf("ключ %q задан дважды", kid)
+		}
+		ks.keys[kid] = key
+	}
+
+	if signingKID == "" {
+		if len(privateIDs) == 0 {
+			return nil, fmt.Errorf("в каталоге %s нет закрытых ключей", dir)
+		}
+		sort.Strings(privateIDs)
+		signingKID = privateIDs[len(privateIDs)-1]
+	}
+
+	signing, ok := ks.keys[signingKID]
+	if !ok || signing.private == nil {
+		return nil, fmt.Errorf("закрытый ключ %q не найден", signingKID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+// NewEphemeralKeySet создаёт одноразовый ключ Ed25519. Подходит только для
+// локальной разработки: после перезапуска все токены становятся
+// недействительными.
+func NewEphemeralKeySet(issuer string) (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{
+		id:      "ephemeral",
+		method:  jwt.SigningMethodEdDSA,
+		private: private,
+		public:  public,
+	}
+
+	return &KeySet{
+		Issuer:  issuer,
+		signing: key,
+		keys:    map[string]*signingKey{key.id: key},
+	}, nil
+}
+
+func parsePrivateKey(kid string, block *pem.Block) (*signingKey, error) {
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("неподдерживаемый тип PEM %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("длина ключа RSA должна быть не менее 2048 бит")
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, private: key, public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, private: key, public: key.Public()}, nil
+	default:
+		return nil, errors.New("поддерживаются только ключи RSA и Ed25519")
+	}
+}
+
+func parsePublicKey(kid string, block *pem.Block) (*signingKey, error) {
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("неподдерживаемый тип PEM %q", block.Type)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, public: key}, nil
+	case ed25519.PublicKey:
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, public: key}, nil
+	default:
+		return nil, errors.New("поддерживаются только ключи RSA и Ed25519")
+	}
+}
+
+// lookup возвращает ключ проверки для заголовка токена и убеждается, что
+// алгоритм токена соответствует типу ключа.
+func (ks *KeySet) lookup(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный ключ %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("алгоритм %s не соответствует ключу %q", token.Method.Alg(), kid)
+	}
+
+	return key.public, nil
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает открытые части всех ключей проверки в формате RFC 7517.
+func (ks *KeySet) JWKS() JWKSet {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{KeyID: id, Use: "sig", Algorithm: key.method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Слепой индекс позволяет искать по зашифрованному тексту: рядом с
+// шифротекстом хранятся HMAC нормализованных слов, и запрос сравнивается с
+// ними, не расшифровывая строки. HMAC обрезается до blindTokenBytes — редкие
+// совпадения разных слов дают лишние результаты, но не пропуски.
+const (
+	blindTokenBytes = 16
+	minStemLength   = 3
+	maxSearchTerms  = 10
+)
+
+// Окончания для облегчённого стемминга. Русские окончания снимаются один
+// раз, казахские — до трёх раз подряд: казахский язык агглютинативный
+// (множественное число, принадлежность и падеж идут друг за другом).
+const kazakhLetters = "әғқңөұүһі"
+
+var (
+	russianReflexive = []string{"ся", "сь"}
+	russianSuffixes  = sortedByLength([]string{
+		"ими", "ыми", "его", "ого", "ему", "ому", "ее", "ие", "ые", "ое", "ей", "ий", "ый", "ой",
+		"ем", "им", "ым", "ом", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею",
+		"ете", "йте", "ешь", "нно", "ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли",
+		"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь",
+		"ет", "ют", "ть",
+		"иями", "ями", "ами", "иях", "ях", "ах", "иям", "ям", "ам", "ием", "ией",
+		"ев", "ов", "ье", "еи", "ии", "ию", "ью", "ия", "ья",
+		"а", "е", "и", "й", "о", "у", "ы", "ь", "ю", "я",
+	})
+	kazakhSuffixes = sortedByLength([]string{
+		"лар", "лер", "дар", "дер", "тар", "тер",
+		"дың", "дің", "тың", "тің", "ның", "нің",
+		"ды", "ді", "ты", "ті", "ны", "ні",
+		"ға", "ге", "қа", "ке", "на", "не",
+		"нда", "нде", "да", "де", "та", "те",
+		"нан", "нен", "дан", "ден", "тан", "тен",
+		"мен", "бен", "пен",
+		"ымыз", "іміз", "ыңыз", "іңіз", "сы", "сі", "ым", "ім", "ың", "ің",
+	})
+)
+
+func sortedByLength(suffixes []string) []string {
+	sort.SliceStable(suffixes, func(i, j int) bool {
+		return utf8.RuneCountInString(suffixes[i]) > utf8.RuneCountInString(suffixes[j])
+	})
+	return suffixes
+}
+
+// SearchTerms разбивает текст на слова и приводит их к нормальной форме:
+// нижний регистр, «ё» → «е», без окончаний. Повторы убираются.
+func SearchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	var terms []string
+	for _, word := range words {
+		if utf8.RuneCountInString(word) < 2 {
+			continue
+		}
+		term := stem(strings.ReplaceAll(word, "ё", "е"))
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// stem снимает окончания. Слово с буквами, которых нет в русском, считается
+// казахским. Слово только из общих букв сначала разбирается как русское, а
+// если русское окончание не подошло — как казахское: иначе «адамдар» не
+// совпало бы с «адам».
+func stem(word string) string {
+	if strings.ContainsAny(word, kazakhLetters) {
+		return stemKazakh(word)
+	}
+
+	stripped := stripSuffix(stripSuffix(word, russianReflexive), russianSuffixes)
+	if stripped != word {
+		return stripped
+	}
+	return stemKazakh(word)
+}
+
+func stemKazakh(word string) string {
+	for i := 0; i < 3; i++ {
+		stripped := stripSuffix(word, kazakhSuffixes)
+		if stripped == word {
+			break
+		}
+		word = stripped
+	}
+	return word
+}
+
+// stripSuffix снимает самое длинное подходящее окончание, если после этого
+// остаётся не меньше minStemLength букв.
+func stripSuffix(word string, suffixes []string) string {
+	length := utf8.RuneCountInString(word)
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(word, suffix) && length-utf8.RuneCountInString(suffix) >= minStemLength {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// BlindToken возвращает слепой индекс одного нормализованного слова.
+func BlindToken(term string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(term))
+	return hex.EncodeToString(mac.Sum(nil)[:blindTokenBytes])
+}
+
+// BlindIndex возвращает слепые индексы всех слов текста для хранения рядом с
+// шифротекстом.
+func BlindIndex(text string, key []byte) []string {
+	return blindTokens(SearchTerms(text), key)
+}
+
+// BlindQuery возвращает слепые индексы слов поискового запроса. Слов
+// учитывается не больше maxSearchTerms.
+func BlindQuery(query string, key []byte) []string {
+	terms := SearchTerms(query)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return blindTokens(terms, key)
+}
+
+func blindTokens(terms []string, key []byte) []string {
+	tokens := make([]string, 0, len(terms))
+	for _, term := range terms {
+		tokens = append(tokens, BlindToken(term, key))
+	}
+	return tokens
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		// Русский
+		{"кража", "краж"},
+		{"кражи", "краж"},
+		{"машину", "машин"},
+		{"телефоны", "телефон"},
+		{"угнали", "угнал"},
+		{"оставался", "оставал"},
+		{"мошенники", "мошенник"},
+		// Слишком короткая основа не обрезается
+		{"дом", "дом"},
+		// Казахский с буквами, которых нет в русском
+		{"кітаптарымыз", "кітап"},
+		{"қалада", "қала"},
+		{"полицияға", "полиция"},
+		// Казахский только из общих с русским букв
+		{"адамдар", "адам"},
+		{"адам", "адам"},
+		{"балалар", "бала"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			if got := stem(tt.word); got != tt.want {
+				t.Errorf("stem(%q) = %q, want %q", tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"пустой текст", "", nil},
+		{"регистр и знаки", "Украли ТЕЛЕФОН!", []string{"украл", "телефон"}},
+		{"повторы", "телефон, телефоны, Телефона", []string{"телефон"}},
+		{"ё как е", "Ёлка и елки", []string{"елк"}},
+		{"одна буква пропускается", "а я", nil},
+		{"цифры", "дом 12", []string{"дом", "12"}},
+		{"оба языка", "Адамдар адам, кража", []string{"адам", "краж"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SearchTerms(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchTerms(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
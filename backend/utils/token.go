@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateOpaqueToken возвращает случайную строку, которую клиент хранит как
+// есть, а сервер — только в виде хеша.
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// humanAlphabet не содержит символов, которые легко спутать при вводе вручную.
+const humanAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// RandomString возвращает строку длины n из humanAlphabet без смещения
+// распределения символов.
+func RandomString(n int) (string, error) {
+	limit := 256 - 256%len(humanAlphabet)
+	result := make([]byte, 0, n)
+	b := make([]byte, 1)
+	for len(result) < n {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		if int(b[0]) < limit {
+			result = append(result, humanAlphabet[int(b[0])%len(humanAlphabet)])
+		}
+	}
+	return string(result), nil
+}
@@ -1,9 +1,22 @@
 package utils
 
 import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
 )
 
+const totpPeriod = 30
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
 func GenerateTOTPSecret(username string) (string, string, error) {
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      "Backend App",
@@ -16,6 +29,44 @@ func GenerateTOTPSecret(username string) (string, string, error) {
 	return key.Secret(), key.URL(), nil
 }
 
-func ValidateTOTP(secret, code string) bool {
-	return totp.Validate(code, secret)
+// ValidateTOTP проверяет код с допуском в один шаг в обе стороны и возвращает
+// номер шага, которому он соответствует. Коды из шагов не новее lastStep
+// отклоняются, поэтому однажды принятый код нельзя предъявить повторно.
+func ValidateTOTP(secret, code string, lastStep int64) (int64, bool) {
+	current := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateRecoveryCodes возвращает n одноразовых кодов восстановления вида
+// xxxxx-xxxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		code, err := RandomString(10)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
 }
@@ -2,6 +2,23 @@
 
 import { cookies } from "next/headers";
 
+// Совпадает с JWT_REFRESH_EXPIRE_HOURS на сервере: сессия живёт, пока жив
+// refresh-токен, а короткий access-токен обновляется по ответу 401.
+const SESSION_MAX_AGE = 60 * 60 * 24 * 30;
+
+function setSessionCookies(token: string, refreshToken: string) {
+  const options = {
+    httpOnly: true,
+    secure: process.env.NODE_ENV === "production",
+    sameSite: "strict" as const,
+    maxAge: SESSION_MAX_AGE,
+    path: "/",
+  };
+
+  cookies().set("authToken", token, options);
+  cookies().set("refreshToken", refreshToken, options);
+}
+
 export async function loginUser(formData: FormData) {
   const username = formData.get("username") as string;
   const password = formData.get("password") as string;
@@ -30,12 +47,7 @@ export async function loginUser(formData: FormData) {
       };
     }
 
-    cookies().set("authToken", data.token, {
-      httpOnly: true,
-      secure: process.env.NODE_ENV === "production",
-      maxAge: 60 * 60 * 24 * 7,
-      path: "/",
-    });
+    setSessionCookies(data.token, data.refresh_token);
 
     return { success: true };
   } catch (error) {
@@ -45,10 +57,59 @@ export async function loginUser(formData: FormData) {
 }
 
 export async function logoutUser() {
+  const token = cookies().get("authToken")?.value;
+
+  if (token) {
+    try {
+      await fetch("http://34.88.151.210:8080/api/auth/logout", {
+        method: "POST",
+        headers: {
+          "Authorization": `Bearer ${token}`,
+        },
+      });
+    } catch (error) {
+      console.error("Ошибка завершения сессии:", error);
+    }
+  }
+
   cookies().delete("authToken");
+  cookies().delete("refreshToken");
   return { success: true };
 }
 
+// Обменивает refresh-токен на новую пару. Сервер ротирует refresh-токен при
+// каждом обмене, поэтому обе cookie перезаписываются; при отказе сессия
+// считается завершённой и cookie удаляются.
+export async function refreshAuthToken() {
+  const refreshToken = cookies().get("refreshToken")?.value;
+  if (!refreshToken) {
+    return null;
+  }
+
+  try {
+    const response = await fetch("http://34.88.151.210:8080/api/auth/refresh", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    });
+
+    if (!response.ok) {
+      cookies().delete("authToken");
+      cookies().delete("refreshToken");
+      return null;
+    }
+
+    const data = await response.json();
+    setSessionCookies(data.token, data.refresh_token);
+    return data.token as string;
+  } catch (error) {
+    console.error("Ошибка обновления токена:", error);
+    return null;
+  }
+}
+
 export async function getAuthToken() {
   const token = cookies().get("authToken")?.value;
   return token;
@@ -1,15 +1,9 @@
 "use server";
 
-import { getAuthToken } from "./auth";
+import { authFetch } from "@/lib/api";
 import { revalidatePath } from "next/cache";
 
 export async function createNews(formData: FormData) {
-  const token = await getAuthToken();
-  
-  if (!token) {
-    return { success: false, error: "Не авторизован" };
-  }
-
   try {
     const serverFormData = new FormData();
     serverFormData.append("title", formData.get("title") as string);
@@ -20,14 +14,15 @@ export async function createNews(formData: FormData) {
       serverFormData.append("image", imageFile);
     }
 
-    const response = await fetch("http://34.88.151.210:8080/api/news", {
+    const response = await authFetch("http://34.88.151.210:8080/api/news", {
       method: "POST",
-      headers: {
-        "Authorization": `Bearer ${token}`,
-      },
       body: serverFormData,
     });
 
+    if (!response) {
+      return { success: false, error: "Не авторизован" };
+    }
+
     const data = await response.json();
 
     if (!response.ok) {
@@ -6,6 +6,7 @@ import { ChatWindow } from "@/components/chat/ChatWindow";
 import { useState, useEffect, useRef } from "react";
 import { v4 as uuidv4 } from "uuid";
 import { toast } from "sonner";
+import { authFetch } from "@/lib/api";
 
 interface Message {
   id: string;
@@ -170,7 +171,33 @@ const mockMessages: MessageMap = {
 };
 
 
-const POLICE_OPERATOR_ID = "203f9207-4541-4e81-9d01-76d3f1ba3d0e";
+// Версия протокола /ws/chat: каждый кадр — конверт {type, id, version, payload}.
+const WS_PROTOCOL_VERSION = 1;
+
+interface WsEnvelope {
+  type: string;
+  id?: string;
+  version: number;
+  payload?: any;
+}
+
+// Браузер не передаёт заголовок Authorization при открытии сокета, поэтому
+// подключение идёт по короткоживущему билету из POST /api/ws/ticket.
+const fetchWebSocketTicket = async (): Promise<string | null> => {
+  const response = await authFetch("http://34.88.151.210:8080/api/ws/ticket", {
+    method: "POST"
+  });
+  if (!response) {
+    return null;
+  }
+
+  if (!response.ok) {
+    throw new Error("Ошибка при получении билета");
+  }
+
+  const data = await response.json();
+  return data.ticket;
+};
 
 export default function ChatPage() {
   const [activeUserId, setActiveUserId] = useState<string | null>("84157d73-c387-4e05-836c-b7c899e58453");
@@ -178,6 +205,9 @@ export default function ChatPage() {
   const [messages, setMessages] = useState<MessageMap>(mockMessages);
   const [isConnected, setIsConnected] = useState(false);
   const socketRef = useRef<WebSocket | null>(null);
+  // client_message_id отправленных отсюда сообщений: сервер присылает их
+  // обратно в chat.message, а они уже показаны.
+  const sentMessageIdsRef = useRef<Set<string>>(new Set());
   const reconnectTimeoutRef = useRef<NodeJS.Timeout | null>(null);
   const [isPageMounted, setIsPageMounted] = useState(false);
 
@@ -188,7 +218,7 @@ export default function ChatPage() {
   }, []);
 
 
-  const connectWebSocket = () => {
+  const connectWebSocket = async () => {
     
     if (!isPageMounted) return;
 
@@ -198,8 +228,14 @@ export default function ChatPage() {
     }
 
     try {
-   
-      const socket = new WebSocket(`ws://34.88.151.210:8080/ws/chat?user_id=${POLICE_OPERATOR_ID}&role=police`);
+      // Билет одноразовый, поэтому при каждом переподключении берётся новый.
+      const ticket = await fetchWebSocketTicket();
+      if (!ticket) {
+        toast.error("Не авторизован");
+        return;
+      }
+
+      const socket = new WebSocket(`ws://34.88.151.210:8080/ws/chat?ticket=${encodeURIComponent(ticket)}`);
       socketRef.current = socket;
 
       socket.onopen = () => {
@@ -219,14 +255,42 @@ export default function ChatPage() {
         if (!isPageMounted) return;
 
         try {
-          const data = JSON.parse(event.data);
-          
-    
-          const newMessage = {
-            id: uuidv4(),
+          const envelope: WsEnvelope = JSON.parse(event.data);
+
+          if (envelope.type === "chat.ack") {
+            // Сервер сохранил сообщение оператора.
+            const clientMessageId = envelope.payload?.client_message_id;
+            setMessages((prevMessages) => {
+              const updated: MessageMap = {};
+              for (const [userId, list] of Object.entries(prevMessages)) {
+                updated[userId] = list.map((message): Message =>
+                  message.id === clientMessageId ? { ...message, status: "delivered" } : message
+                );
+              }
+              return updated;
+            });
+            return;
+          }
+
+          if (envelope.type === "error") {
+            toast.error(envelope.payload?.message || "Ошибка сервера");
+            return;
+          }
+
+          if (envelope.type !== "chat.message" || !envelope.payload?.message?.sender_id) {
+            return;
+          }
+
+          const data = envelope.payload.message;
+          if (data.client_message_id && sentMessageIdsRef.current.has(data.client_message_id)) {
+            return;
+          }
+
+          const newMessage: Message = {
+            id: data.id,
             content: data.message,
             sender: "user",
-            timestamp: new Date(),
+            timestamp: new Date(data.created_at),
             status: "delivered",
           };
 
@@ -350,14 +414,25 @@ export default function ChatPage() {
     }
 
  
-    socketRef.current.send(JSON.stringify({
-      recipient_id: activeUserId,
-      message: content
-    }));
+    // client_message_id защищает от дубликата при повторной отправке и
+    // связывает сообщение с подтверждением chat.ack.
+    const clientMessageId = uuidv4();
+    sentMessageIdsRef.current.add(clientMessageId);
+    const envelope: WsEnvelope = {
+      type: "chat.send",
+      id: uuidv4(),
+      version: WS_PROTOCOL_VERSION,
+      payload: {
+        client_message_id: clientMessageId,
+        recipient_id: activeUserId,
+        message: content
+      }
+    };
+    socketRef.current.send(JSON.stringify(envelope));
 
    
-    const newMessage = {
-      id: uuidv4(),
+    const newMessage: Message = {
+      id: clientMessageId,
       content,
       sender: "operator",
       timestamp: new Date(),
@@ -5,7 +5,7 @@ import { IncidentsList, Incident } from "@/components/incidents/IncidentsList";
 import { IncidentDetail } from "@/components/incidents/IncidentDetail";
 import { useState, useEffect } from "react";
 import { v4 as uuidv4 } from "uuid";
-import { getAuthToken } from "@/actions/auth";
+import { authFetch } from "@/lib/api";
 import { toast } from "sonner";
 import Image from "next/image";
 import { MapPin } from "lucide-react";
@@ -30,39 +30,52 @@ export default function IncidentsPage() {
   const [activeIncidentId, setActiveIncidentId] = useState<string | null>(null);
   const [showMobileDetail, setShowMobileDetail] = useState(false);
   const [loading, setLoading] = useState(true);
+  const [loadingMore, setLoadingMore] = useState(false);
+  const [nextCursor, setNextCursor] = useState("");
+  const [total, setTotal] = useState(0);
+
+  // Сервер отдаёт инциденты страницами; следующая запрашивается по next_cursor.
+  const fetchIncidents = async (cursor?: string) => {
+    const url = cursor
+      ? `http://34.88.151.210:8080/api/incidents?cursor=${encodeURIComponent(cursor)}`
+      : "http://34.88.151.210:8080/api/incidents";
+    const response = await authFetch(url);
+    if (!response) {
+      toast.error("Не авторизован");
+      return;
+    }
 
-  useEffect(() => {
-    const fetchIncidents = async () => {
-      try {
-        const token = await getAuthToken();
-        if (!token) {
-          toast.error("Не авторизован");
-          return;
-        }
-
-        const response = await fetch("http://34.88.151.210:8080/api/incidents", {
-          headers: {
-            "Authorization": `Bearer ${token}`
-          }
-        });
+    if (!response.ok) {
+      throw new Error("Ошибка при получении данных");
+    }
 
-        if (!response.ok) {
-          throw new Error("Ошибка при получении данных");
-        }
+    const data = await response.json();
+    setIncidents((prevIncidents) => cursor ? [...prevIncidents, ...data.incidents] : data.incidents);
+    setNextCursor(data.next_cursor);
+    setTotal(data.total);
+  };
 
-        const data = await response.json();
-        setIncidents(data);
-      } catch (error) {
+  useEffect(() => {
+    fetchIncidents()
+      .catch((error) => {
         console.error("Ошибка при загрузке инцидентов:", error);
         toast.error("Не удалось загрузить инциденты");
-      } finally {
-        setLoading(false);
-      }
-    };
-
-    fetchIncidents();
+      })
+      .finally(() => setLoading(false));
   }, []);
 
+  const handleLoadMore = async () => {
+    setLoadingMore(true);
+    try {
+      await fetchIncidents(nextCursor);
+    } catch (error) {
+      console.error("Ошибка при загрузке инцидентов:", error);
+      toast.error("Не удалось загрузить инциденты");
+    } finally {
+      setLoadingMore(false);
+    }
+  };
+
   const activeIncident = activeIncidentId
     ? incidents.find((incident) => incident.id === activeIncidentId) || null
     : null;
@@ -80,21 +93,18 @@ export default function IncidentsPage() {
 
   const handleReply = async (incidentId: string, reply: string) => {
     try {
-      const token = await getAuthToken();
-      if (!token) {
-        toast.error("Не авторизован");
-        return;
-      }
-
       // Отправка ответа на сервер
-      const response = await fetch(`http://34.88.151.210:8080/api/incidents/${incidentId}/messages`, {
+      const response = await authFetch(`http://34.88.151.210:8080/api/incidents/${incidentId}/messages`, {
         method: "POST",
         headers: {
-          "Authorization": `Bearer ${token}`,
           "Content-Type": "application/json"
         },
         body: JSON.stringify({ message: reply })
       });
+      if (!response) {
+        toast.error("Не авторизован");
+        return;
+      }
 
       if (!response.ok) {
         throw new Error("Ошибка при отправке ответа");
@@ -132,12 +142,6 @@ export default function IncidentsPage() {
 
   const handleToggleReadStatus = async (incidentId: string) => {
     try {
-      const token = await getAuthToken();
-      if (!token) {
-        toast.error("Не авторизован");
-        return;
-      }
-
       const currentIncident = incidents.find(inc => inc.id === incidentId);
       if (!currentIncident) return;
 
@@ -151,12 +155,13 @@ export default function IncidentsPage() {
         return;
       }
 
-      const response = await fetch(`http://34.88.151.210:8080/api/incidents/${incidentId}/read`, {
-        method: "POST",
-        headers: {
-          "Authorization": `Bearer ${token}`
-        }
+      const response = await authFetch(`http://34.88.151.210:8080/api/incidents/${incidentId}/read`, {
+        method: "POST"
       });
+      if (!response) {
+        toast.error("Не авторизован");
+        return;
+      }
 
       if (!response.ok) {
         throw new Error("Ошибка при отметке инцидента как прочитанного");
@@ -199,6 +204,10 @@ export default function IncidentsPage() {
                 }))}
                 activeIncidentId={activeIncidentId}
                 onSelectIncident={handleSelectIncident}
+                total={total}
+                hasMore={!!nextCursor}
+                loadingMore={loadingMore}
+                onLoadMore={handleLoadMore}
               />
             </div>
             <div className={`${!showMobileDetail ? "hidden md:block" : "block"}`}>
@@ -1,6 +1,7 @@
 "use client";
 
 import { Badge } from "@/components/ui/badge";
+import { Button } from "@/components/ui/button";
 import { cn } from "@/lib/utils";
 import { format } from "date-fns";
 import { ru } from "date-fns/locale";
@@ -22,19 +23,28 @@ interface IncidentsListProps {
   incidents: Incident[];
   activeIncidentId: string | null;
   onSelectIncident: (id: string) => void;
+  // Сколько всего обращений подходит под фильтры; список может быть загружен не полностью.
+  total?: number;
+  hasMore?: boolean;
+  loadingMore?: boolean;
+  onLoadMore?: () => void;
 }
 
 export function IncidentsList({
   incidents,
   activeIncidentId,
   onSelectIncident,
+  total,
+  hasMore,
+  loadingMore,
+  onLoadMore,
 }: IncidentsListProps) {
   return (
     <div className="h-full border-r border-gray-200 dark:border-gray-700 overflow-hidden flex flex-col">
       <div className="h-14 border-b border-gray-200 dark:border-gray-700 flex items-center justify-between px-4">
         <h2 className="font-semibold">Обращения</h2>
         <span className="text-sm text-gray-500">
-          {incidents.length} обращений
+          {total ?? incidents.length} обращений
         </span>
       </div>
       <div className="overflow-auto flex-1">
@@ -94,6 +104,13 @@ export function IncidentsList({
             )}
           </div>
         ))}
+        {hasMore && onLoadMore && (
+          <div className="p-3 flex justify-center">
+            <Button variant="outline" size="sm" onClick={onLoadMore} disabled={loadingMore}>
+              {loadingMore ? "Загрузка..." : "Загрузить ещё"}
+            </Button>
+          </div>
+        )}
       </div>
     </div>
   );
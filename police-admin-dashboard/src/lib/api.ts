@@ -0,0 +1,27 @@
+import { getAuthToken, refreshAuthToken } from "@/actions/auth";
+
+// Выполняет запрос к API с access-токеном из cookie. Access-токен живёт
+// недолго, поэтому на 401 он один раз обновляется по refresh-токену и запрос
+// повторяется. Возвращает null, если сессии нет или обновить её не удалось.
+export async function authFetch(url: string, init: RequestInit = {}): Promise<Response | null> {
+  const send = (token: string) => {
+    const headers = new Headers(init.headers);
+    headers.set("Authorization", `Bearer ${token}`);
+    return fetch(url, { ...init, headers });
+  };
+
+  const token = await getAuthToken();
+  if (token) {
+    const response = await send(token);
+    if (response.status !== 401) {
+      return response;
+    }
+  }
+
+  const refreshed = await refreshAuthToken();
+  if (!refreshed) {
+    return null;
+  }
+
+  return send(refreshed);
+}
@@ -2,7 +2,9 @@ import { NextResponse } from "next/server";
 import type { NextRequest } from "next/server";
 
 export function middleware(request: NextRequest) {
-  const authToken = request.cookies.get("authToken");
+  // Просроченный access-токен обновляется по refresh-токену при первом же
+  // запросе к API, поэтому для входа достаточно любой из двух cookie.
+  const authToken = request.cookies.get("authToken") || request.cookies.get("refreshToken");
   const { pathname } = request.nextUrl;
 
   const publicRoutes = ["/login"];